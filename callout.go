@@ -0,0 +1,145 @@
+package pcre2
+
+/*
+#define PCRE2_CODE_UNIT_WIDTH 8
+#include <stdint.h>
+#include <pcre2.h>
+*/
+import "C"
+import (
+	"context"
+	"runtime/cgo"
+	"unsafe"
+)
+
+// Actions that a CalloutFunc may return
+const (
+	// CalloutContinue continues matching as normal
+	CalloutContinue CalloutAction = 0
+	// CalloutFail fails the current matching path, and causes
+	// PCRE2 to backtrack
+	CalloutFail CalloutAction = 1
+	// CalloutAbort aborts the whole match. The match then fails
+	// with a MatchError that matches ErrCalloutAbort
+	CalloutAbort CalloutAction = C.PCRE2_ERROR_CALLOUT
+)
+
+// SetCallout registers a function that is called whenever a callout
+// such as (?C1) or (?C"name") is reached while matching. Passing nil
+// removes the callout function.
+//
+// The function may be called concurrently if the Regexp is used from
+// multiple goroutines. If the function panics, the match is aborted
+// and the panic is propagated to the caller of the match method.
+//
+// The function may call Free on the Regexp, but must not call methods
+// that modify it, such as SetCallout, SetLimits or JIT, as they wait
+// for the match that called the function to finish.
+//
+// Methods such as MatchContext check their context at each callout,
+// and abort the match once it is cancelled.
+//
+// SetCallout waits for matches that are in progress to finish, so it
+// is best called before the Regexp is shared between goroutines.
+func (r *Regexp) SetCallout(fn CalloutFunc) {
+	if _, err := r.lock(); err != nil {
+		return
+	}
+	defer r.unlock()
+
+	r.callout = fn
+}
+
+// newCalloutState returns the state for a single match, or nil if the
+// Regexp has no callout function. The match is aborted at the next
+// callout once ctx is cancelled.
+func (r *Regexp) newCalloutState(ctx context.Context) *calloutState {
+	if r.callout == nil {
+		return nil
+	}
+	return &calloutState{fn: r.callout, ctx: ctx}
+}
+
+// calloutState holds the per-match data that is needed when PCRE2
+// calls back into Go
+type calloutState struct {
+	fn         CalloutFunc
+	ctx        context.Context
+	panicked   bool
+	panicValue interface{}
+}
+
+// rethrow propagates a panic that occurred in the callout function
+func (s *calloutState) rethrow() {
+	if s != nil && s.panicked {
+		panic(s.panicValue)
+	}
+}
+
+// byteOffset converts an offset reported by PCRE2 to an int, using -1
+// for unset offsets
+func byteOffset(offset C.PCRE2_SIZE) int {
+	if offset == C.PCRE2_UNSET {
+		return -1
+	}
+	return int(offset)
+}
+
+func codeUnitsToString(p C.PCRE2_SPTR, l int) string {
+	if p == nil || l == 0 {
+		return ""
+	}
+	return C.GoStringN((*C.char)(unsafe.Pointer(p)), C.int(l))
+}
+
+func zeroTerminatedString(p C.PCRE2_SPTR) string {
+	if p == nil {
+		return ""
+	}
+	return C.GoString((*C.char)(unsafe.Pointer(p)))
+}
+
+func (s *calloutState) newCallout(block *C.pcre2_callout_block) *Callout {
+	c := &Callout{
+		Number:          int(block.callout_number),
+		StartMatch:      byteOffset(block.start_match),
+		SubjectPosition: byteOffset(block.current_position),
+		PatternPosition: int(block.pattern_position),
+	}
+
+	if block.callout_string != nil {
+		c.String = codeUnitsToString(block.callout_string, int(block.callout_string_length))
+	}
+
+	c.Mark = zeroTerminatedString(block.mark)
+
+	// Group 0 has not been set yet, so we report the current
+	// match attempt instead
+	top := int(block.capture_top)
+	ovector := unsafe.Slice(block.offset_vector, 2*top)
+	c.Captures = make([]int, 0, 2*top)
+	c.Captures = append(c.Captures, c.StartMatch, c.SubjectPosition)
+	for i := 2; i < 2*top; i++ {
+		c.Captures = append(c.Captures, byteOffset(ovector[i]))
+	}
+	return c
+}
+
+//export goCallout
+func goCallout(block *C.pcre2_callout_block, handle C.uintptr_t) (rc C.int) {
+	s := cgo.Handle(handle).Value().(*calloutState)
+
+	if s.ctx.Err() != nil {
+		return C.PCRE2_ERROR_CALLOUT
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			s.panicked = true
+			s.panicValue = v
+			rc = C.PCRE2_ERROR_CALLOUT
+		}
+	}()
+
+	return C.int(s.fn(s.newCallout(block)))
+}
@@ -0,0 +1,118 @@
+package pcre2
+
+/*
+#define PCRE2_CODE_UNIT_WIDTH 8
+#include <pcre2.h>
+*/
+import "C"
+import (
+	"context"
+	"unsafe"
+)
+
+// contextMatchLimitStep is the match limit used for the first attempt
+// when matching with a cancellable context. Each subsequent attempt
+// doubles the limit, so the total amount of work is at most twice that
+// of a single unbounded match.
+const contextMatchLimitStep = 100000
+
+// builtinMatchLimit returns the match limit that PCRE2 uses when none
+// is set in the match context
+func builtinMatchLimit() uint32 {
+	var i C.uint32_t
+	C.pcre2_config(C.PCRE2_CONFIG_MATCHLIMIT, unsafe.Pointer(&i))
+	return uint32(i)
+}
+
+// matchSubjectContext is like matchSubject, but aborts the match
+// when ctx is cancelled. The caller must have acquired rptr.
+//
+// Once pcre2_match has started it cannot be interrupted from Go, so
+// the match is performed with a small match limit. Whenever that limit
+// is hit ctx is checked, and the match is retried with a doubled limit
+// until the real limit is reached. Retrying would call the callout
+// function again for positions that it has already seen, so a Regexp
+// with a callout function is matched once instead, and ctx is checked
+// at each callout.
+func (r *Regexp) matchSubjectContext(ctx context.Context, rptr *C.pcre2_code, subject []byte, offset int, options int, matchData *C.pcre2_match_data, limits *Limits) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return -1, err
+	}
+
+	if ctx.Done() == nil || r.callout != nil {
+		rc, err := r.matchSubject(ctx, rptr, subject, offset, options, matchData, limits)
+		if err != nil {
+			return rc, err
+		}
+		if err := ctx.Err(); err != nil && rc == C.PCRE2_ERROR_CALLOUT {
+			return rc, err
+		}
+		return rc, matchError(rc)
+	}
+
+	l := r.effectiveLimits(limits)
+	max := l.Match
+	if max == 0 {
+		max = builtinMatchLimit()
+	}
+
+	for step := uint32(contextMatchLimitStep); ; step *= 2 {
+		if step >= max || step == 0 {
+			l.Match = max
+		} else {
+			l.Match = step
+		}
+
+		rc, err := r.matchSubject(ctx, rptr, subject, offset, options, matchData, &l)
+		if err != nil {
+			return rc, err
+		}
+		if rc != C.PCRE2_ERROR_MATCHLIMIT || l.Match == max {
+			return rc, matchError(rc)
+		}
+
+		if err := ctx.Err(); err != nil {
+			return rc, err
+		}
+	}
+}
+
+// MatchContext is like Match, but aborts when ctx is cancelled or
+// its deadline passes. In that case ctx.Err() is returned.
+func (r *Regexp) MatchContext(ctx context.Context, b []byte) (bool, error) {
+	return r.matchWithLimits(ctx, b, nil)
+}
+
+// MatchStringContext is like MatchString, but aborts when ctx is
+// cancelled or its deadline passes. In that case ctx.Err() is returned.
+func (r *Regexp) MatchStringContext(ctx context.Context, s string) (bool, error) {
+	return r.matchWithLimits(ctx, stringBytes(s), nil)
+}
+
+// FindAllIndexContext is like FindAllIndex, but aborts when ctx is
+// cancelled or its deadline passes. In that case the matches found so
+// far are returned along with ctx.Err().
+func (r *Regexp) FindAllIndexContext(ctx context.Context, b []byte, n int) ([][]int, error) {
+	return r.findAllIndex(ctx, b, n, nil)
+}
+
+// FindAllStringIndexContext is like FindAllStringIndex, but aborts
+// when ctx is cancelled or its deadline passes. In that case the
+// matches found so far are returned along with ctx.Err().
+func (r *Regexp) FindAllStringIndexContext(ctx context.Context, s string, n int) ([][]int, error) {
+	return r.findAllIndex(ctx, stringBytes(s), n, nil)
+}
+
+// FindAllSubmatchIndexContext is like FindAllSubmatchIndex, but aborts
+// when ctx is cancelled or its deadline passes. In that case the
+// matches found so far are returned along with ctx.Err().
+func (r *Regexp) FindAllSubmatchIndexContext(ctx context.Context, b []byte, n int) ([][]int, error) {
+	return r.findAllSubmatchIndex(ctx, b, n, nil)
+}
+
+// FindAllStringSubmatchIndexContext is like FindAllStringSubmatchIndex,
+// but aborts when ctx is cancelled or its deadline passes. In that case
+// the matches found so far are returned along with ctx.Err().
+func (r *Regexp) FindAllStringSubmatchIndexContext(ctx context.Context, s string, n int) ([][]int, error) {
+	return r.findAllSubmatchIndex(ctx, stringBytes(s), n, nil)
+}
@@ -0,0 +1,177 @@
+package pcre2
+
+/*
+#define PCRE2_CODE_UNIT_WIDTH 8
+#include <pcre2.h>
+*/
+import "C"
+import (
+	"context"
+	"unsafe"
+)
+
+// DFA matching options. These may be OR'ed together
+const (
+	// DFAShortest stops matching as soon as the shortest match has
+	// been found, so only that match is returned
+	DFAShortest DFAOption = C.PCRE2_DFA_SHORTEST
+	// DFARestart continues a partial match with the next piece of the
+	// subject. It can only be given to DFAWorkspace.Match, after the
+	// previous call on the same workspace returned ErrPartialMatch
+	DFARestart DFAOption = C.PCRE2_DFA_RESTART
+	// DFAPartialSoft is like PartialSoft, for DFA matching
+	DFAPartialSoft DFAOption = C.PCRE2_PARTIAL_SOFT
+	// DFAPartialHard is like PartialHard, for DFA matching
+	DFAPartialHard DFAOption = C.PCRE2_PARTIAL_HARD
+)
+
+const (
+	// dfaWorkspaceSize is the initial number of ints in a workspace.
+	// The workspace is grown as required, up to dfaMaxWorkspaceSize
+	dfaWorkspaceSize    = 1000
+	dfaMaxWorkspaceSize = 1 << 20
+	// dfaMatches is the initial number of matches that there is room
+	// for in the ovector
+	dfaMatches = 16
+)
+
+// DFAMatch matches the Regexp against subject using PCRE2's DFA
+// algorithm. Instead of the first match that a Perl-style search finds,
+// it returns every match that starts at the leftmost position where
+// there is a match, longest first, each as a pair of byte offsets. With
+// DFAShortest only the shortest match is returned. A nil result
+// indicates no match.
+//
+// DFA matching does not support every feature of the pattern language:
+//
+//   - Capturing parentheses are treated as non-capturing, so only the
+//     offsets of whole matches are returned.
+//   - Back references, backtracking control verbs such as (*PRUNE),
+//     and conditions that test back references are not supported.
+//   - InvalidUTF8Skip mode is not supported.
+//   - Greedy and lazy quantifiers behave the same, as every match is
+//     found anyway. However, PCRE2 turns repeats such as a+ at the end
+//     of a pattern into possessive ones, which only match the longest
+//     possible string. Compile with NoAutoPossess to keep every match.
+//   - The pattern is never run as JIT compiled code.
+//
+// Using a feature that is not supported fails with a MatchError that
+// satisfies errors.Is(err, ErrDFAUnsupported).
+//
+// The working space that the algorithm needs is allocated and grown
+// as required. To reuse it between calls, or to continue a partial
+// match with DFARestart, use a DFAWorkspace.
+func (r *Regexp) DFAMatch(subject []byte, options ...DFAOption) ([][]int, error) {
+	return r.NewDFAWorkspace().Match(subject, options...)
+}
+
+// DFAMatchString is like DFAMatch, but matches against s
+func (r *Regexp) DFAMatchString(s string, options ...DFAOption) ([][]int, error) {
+	return r.NewDFAWorkspace().Match(stringBytes(s), options...)
+}
+
+// NewDFAWorkspace returns a working space for DFA matching against
+// this Regexp. A DFAWorkspace must not be used by multiple goroutines
+// at the same time.
+func (r *Regexp) NewDFAWorkspace() *DFAWorkspace {
+	return &DFAWorkspace{regexp: r}
+}
+
+// Match is like Regexp.DFAMatch, but uses the working space of w.
+//
+// If DFAPartialSoft or DFAPartialHard is given and only a partial
+// match is found, the offsets of the partial match are returned along
+// with ErrPartialMatch. Matching can then be continued by calling Match
+// again with the next piece of the subject and DFARestart, in which
+// case the offsets that are returned are relative to the new piece.
+func (w *DFAWorkspace) Match(subject []byte, options ...DFAOption) ([][]int, error) {
+	var flags DFAOption
+	for _, o := range options {
+		flags |= o
+	}
+
+	r := w.regexp
+	rptr, err := r.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer r.release()
+
+	restart := flags&DFARestart != 0
+	if restart && !w.partial {
+		return nil, MatchError{code: C.PCRE2_ERROR_DFA_BADRESTART, message: errorMessage(C.PCRE2_ERROR_DFA_BADRESTART)}
+	}
+	w.partial = false
+	if w.ws == nil {
+		w.ws = make([]int32, dfaWorkspaceSize)
+	}
+
+	callout := r.newCalloutState(context.Background())
+	matchContext, release := r.newMatchContext(r.effectiveLimits(nil), callout)
+	defer release()
+
+	subject, repl := r.prepareSubject(subject)
+	matches := dfaMatches
+	for {
+		rc, out := w.match(rptr, subject, flags, matchContext, matches, repl)
+		callout.rethrow()
+
+		switch {
+		case rc == 0:
+			// There are more matches than fit in the ovector
+			matches *= 2
+			continue
+		case rc == C.PCRE2_ERROR_DFA_WSSIZE && !restart && len(w.ws) < dfaMaxWorkspaceSize:
+			// The workspace of a restarted match holds the state of
+			// the previous match, so it can only be grown otherwise
+			w.ws = make([]int32, 2*len(w.ws))
+			continue
+		case rc == C.PCRE2_ERROR_PARTIAL:
+			w.partial = true
+			return out, ErrPartialMatch
+		case rc < 0:
+			return nil, matchError(rc)
+		}
+		return out, nil
+	}
+}
+
+// match runs pcre2_dfa_match once, with room for the given number of
+// matches in the ovector
+func (w *DFAWorkspace) match(rptr *C.pcre2_code, subject []byte, flags DFAOption, matchContext *C.pcre2_match_context, matches int, repl utf8Replacements) (int, [][]int) {
+	matchData := C.pcre2_match_data_create(C.uint32_t(matches), nil)
+	defer C.pcre2_match_data_free(matchData)
+
+	rc := dfaMatch(rptr, subject, 0, C.uint32_t(flags), matchData, matchContext, w.ws)
+
+	// A partial match is reported in the first pair
+	n := rc
+	if rc == C.PCRE2_ERROR_PARTIAL {
+		n = 1
+	}
+	if n <= 0 {
+		return rc, nil
+	}
+
+	ovector := pcre2GetOvectorPointer(matchData, n)
+	out := make([][]int, n)
+	for i := range out {
+		out[i] = ovectorToIndex(ovector[2*i:], 1, repl)
+	}
+	return rc, out
+}
+
+// dfaMatch runs pcre2_dfa_match once, using ws as the workspace
+func dfaMatch(rptr *C.pcre2_code, subject []byte, offset int, options C.uint32_t, matchData *C.pcre2_match_data, matchContext *C.pcre2_match_context, ws []int32) int {
+	return int(C.pcre2_dfa_match(
+		rptr,
+		subjectPtr(subject),
+		C.size_t(len(subject)),
+		C.PCRE2_SIZE(offset),
+		options,
+		matchData,
+		matchContext,
+		(*C.int)(unsafe.Pointer(&ws[0])),
+		C.size_t(len(ws)),
+	))
+}
@@ -0,0 +1,73 @@
+package pcre2
+
+import "context"
+
+// The methods in this file mirror their counterparts without the E
+// suffix, but report failures instead of treating them as "no match".
+// The error is ErrInvalidRegexp if the Regexp has been freed, or a
+// MatchError if PCRE2 failed while matching, for example because the
+// subject is not valid UTF-8 or a limit was exceeded. No match is not
+// an error.
+
+// MatchE is like Match, but also returns an error if matching failed.
+func (r *Regexp) MatchE(b []byte) (bool, error) {
+	return r.matchWithLimits(context.Background(), b, nil)
+}
+
+// MatchStringE is like MatchString, but also returns an error if
+// matching failed.
+func (r *Regexp) MatchStringE(s string) (bool, error) {
+	return r.matchWithLimits(context.Background(), stringBytes(s), nil)
+}
+
+// FindIndexE is like FindIndex, but also returns an error if matching
+// failed.
+func (r *Regexp) FindIndexE(b []byte) ([]int, error) {
+	return r.findIndex(b)
+}
+
+// FindStringIndexE is like FindStringIndex, but also returns an error
+// if matching failed.
+func (r *Regexp) FindStringIndexE(s string) ([]int, error) {
+	return r.findIndex(stringBytes(s))
+}
+
+// FindSubmatchIndexE is like FindSubmatchIndex, but also returns an
+// error if matching failed.
+func (r *Regexp) FindSubmatchIndexE(b []byte) ([]int, error) {
+	return r.findSubmatchIndex(b)
+}
+
+// FindStringSubmatchIndexE is like FindStringSubmatchIndex, but also
+// returns an error if matching failed.
+func (r *Regexp) FindStringSubmatchIndexE(s string) ([]int, error) {
+	return r.findSubmatchIndex(stringBytes(s))
+}
+
+// FindAllIndexE is like FindAllIndex, but also returns an error if
+// matching failed. In that case the matches found so far are returned
+// along with the error.
+func (r *Regexp) FindAllIndexE(b []byte, n int) ([][]int, error) {
+	return r.findAllIndex(context.Background(), b, n, nil)
+}
+
+// FindAllStringIndexE is like FindAllStringIndex, but also returns an
+// error if matching failed. In that case the matches found so far are
+// returned along with the error.
+func (r *Regexp) FindAllStringIndexE(s string, n int) ([][]int, error) {
+	return r.findAllIndex(context.Background(), stringBytes(s), n, nil)
+}
+
+// FindAllSubmatchIndexE is like FindAllSubmatchIndex, but also returns
+// an error if matching failed. In that case the matches found so far
+// are returned along with the error.
+func (r *Regexp) FindAllSubmatchIndexE(b []byte, n int) ([][]int, error) {
+	return r.findAllSubmatchIndex(context.Background(), b, n, nil)
+}
+
+// FindAllStringSubmatchIndexE is like FindAllStringSubmatchIndex, but
+// also returns an error if matching failed. In that case the matches
+// found so far are returned along with the error.
+func (r *Regexp) FindAllStringSubmatchIndexE(s string, n int) ([][]int, error) {
+	return r.findAllSubmatchIndex(context.Background(), stringBytes(s), n, nil)
+}
@@ -0,0 +1,43 @@
+package pcre2
+
+import "sync/atomic"
+
+// LeakFunc is the type of functions that can be registered with
+// SetLeakDetector. It receives the pattern of the leaked Regexp, along
+// with the stack trace of the goroutine that compiled it.
+type LeakFunc func(pattern string, stack []byte)
+
+var leakFunc atomic.Value
+
+func init() {
+	leakFunc.Store(LeakFunc(nil))
+}
+
+// SetLeakDetector registers a function that is called whenever a
+// Regexp is garbage collected without having been freed. Only Regexps
+// that are compiled while a leak detector is registered are reported,
+// as recording the stack trace of every compilation is expensive.
+// Passing nil disables leak detection.
+//
+// The function is called from the finalizer goroutine, so it should
+// return quickly.
+func SetLeakDetector(fn LeakFunc) {
+	leakFunc.Store(fn)
+}
+
+func leakDetector() LeakFunc {
+	return leakFunc.Load().(LeakFunc)
+}
+
+// finalize releases the C resources of a Regexp that has become
+// unreachable without being freed
+func (r *Regexp) finalize() {
+	if r.ptr == nil {
+		return
+	}
+
+	if fn := leakDetector(); fn != nil && r.stack != nil {
+		fn(r.pattern, r.stack)
+	}
+	r.free()
+}
@@ -0,0 +1,110 @@
+package pcre2
+
+/*
+#define PCRE2_CODE_UNIT_WIDTH 8
+#include <pcre2.h>
+
+#ifndef PCRE2_NEWLINE_NUL
+#define PCRE2_NEWLINE_NUL 6
+#endif
+*/
+import "C"
+import "unsafe"
+
+// Newline conventions. See pcre2pattern(3) for the details
+const (
+	NewlineCR      Newline = C.PCRE2_NEWLINE_CR
+	NewlineLF      Newline = C.PCRE2_NEWLINE_LF
+	NewlineCRLF    Newline = C.PCRE2_NEWLINE_CRLF
+	NewlineAny     Newline = C.PCRE2_NEWLINE_ANY
+	NewlineAnyCRLF Newline = C.PCRE2_NEWLINE_ANYCRLF
+	// NewlineNUL requires PCRE2 10.30
+	NewlineNUL Newline = C.PCRE2_NEWLINE_NUL
+)
+
+// Settings for what \R matches
+const (
+	// BSRUnicode makes \R match any Unicode line ending
+	BSRUnicode BSR = C.PCRE2_BSR_UNICODE
+	// BSRAnyCRLF makes \R match only CR, LF or CRLF
+	BSRAnyCRLF BSR = C.PCRE2_BSR_ANYCRLF
+)
+
+// Info returns information about the compiled pattern, which can be
+// used to screen patterns, or to build prefilters that skip subjects
+// that cannot possibly match.
+func (r *Regexp) Info() (Info, error) {
+	rptr, err := r.acquire()
+	if err != nil {
+		return Info{}, err
+	}
+	defer r.release()
+
+	info := Info{
+		CaptureCount:  r.subexps,
+		BackRefMax:    int(infoUint32(rptr, C.PCRE2_INFO_BACKREFMAX)),
+		MinLength:     int(infoUint32(rptr, C.PCRE2_INFO_MINLENGTH)),
+		MaxLookbehind: int(infoUint32(rptr, C.PCRE2_INFO_MAXLOOKBEHIND)),
+		FirstCodeUnit: -1,
+		LastCodeUnit:  -1,
+		HasBackslashC: infoUint32(rptr, C.PCRE2_INFO_HASBACKSLASHC) != 0,
+		HasCRorLF:     infoUint32(rptr, C.PCRE2_INFO_HASCRORLF) != 0,
+		JITSize:       int(infoSize(rptr, C.PCRE2_INFO_JITSIZE)),
+		Size:          int(infoSize(rptr, C.PCRE2_INFO_SIZE)),
+		Limits: Limits{
+			Match: infoUint32(rptr, C.PCRE2_INFO_MATCHLIMIT),
+			Depth: infoUint32(rptr, C.PCRE2_INFO_DEPTHLIMIT),
+			Heap:  infoUint32(rptr, C.PCRE2_INFO_HEAPLIMIT),
+		},
+		Newline: Newline(infoUint32(rptr, C.PCRE2_INFO_NEWLINE)),
+		BSR:     BSR(infoUint32(rptr, C.PCRE2_INFO_BSR)),
+	}
+
+	switch infoUint32(rptr, C.PCRE2_INFO_FIRSTCODETYPE) {
+	case 1:
+		info.FirstCodeUnit = int(infoUint32(rptr, C.PCRE2_INFO_FIRSTCODEUNIT))
+	case 2:
+		info.StartOfLine = true
+	}
+
+	var bitmap *C.uint8_t
+	C.pcre2_pattern_info(rptr, C.PCRE2_INFO_FIRSTBITMAP, unsafe.Pointer(&bitmap))
+	if bitmap != nil {
+		// The bitmap has one bit for each byte value, starting with
+		// the least significant bit of the first byte
+		bits := unsafe.Slice((*byte)(unsafe.Pointer(bitmap)), 32)
+		info.FirstCodeUnits = []byte{}
+		for c := 0; c < 256; c++ {
+			if bits[c/8]&(1<<(c%8)) != 0 {
+				info.FirstCodeUnits = append(info.FirstCodeUnits, byte(c))
+			}
+		}
+	}
+
+	if infoUint32(rptr, C.PCRE2_INFO_LASTCODETYPE) == 1 {
+		info.LastCodeUnit = int(infoUint32(rptr, C.PCRE2_INFO_LASTCODEUNIT))
+	}
+
+	return info, nil
+}
+
+// infoUint32 returns a piece of information about the compiled pattern
+// that PCRE2 reports as a uint32_t. Information that is not set, such
+// as a limit that the pattern does not specify, is reported as 0.
+func infoUint32(rptr *C.pcre2_code, what C.uint32_t) uint32 {
+	var i C.uint32_t
+	if C.pcre2_pattern_info(rptr, what, unsafe.Pointer(&i)) != 0 {
+		return 0
+	}
+	return uint32(i)
+}
+
+// infoSize is like infoUint32, for information that PCRE2 reports as
+// a size_t
+func infoSize(rptr *C.pcre2_code, what C.uint32_t) uint64 {
+	var i C.size_t
+	if C.pcre2_pattern_info(rptr, what, unsafe.Pointer(&i)) != 0 {
+		return 0
+	}
+	return uint64(i)
+}
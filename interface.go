@@ -1,21 +1,121 @@
 package pcre2
 
-import "errors"
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
 
 // Regexp represents a compiled regular expression. Internally
 // it wraps a reference to `pcre2_code` type.
 type Regexp struct {
-	pattern string
-	ptr uintptr // *C.pcre2_code
+	mu          sync.RWMutex // held for writing while ptr is modified
+	refs        atomic.Int64 // references to ptr, see acquire
+	freed       atomic.Bool  // set by Free
+	pattern     string
+	ptr         unsafe.Pointer // *C.pcre2_code
+	jit         JITOption
+	jitStacks   *jitStackPool
+	limits      Limits
+	callout     CalloutFunc
+	subexps     int // number of capturing groups
+	names       []nameEntry
+	invalidUTF8 InvalidUTF8Mode
+	longest     bool         // leftmost-longest matching, see Longest
+	posix       bool         // pattern is POSIX syntax, see CompilePOSIX
+	stack       []byte       // where the Regexp was compiled, for leak reports
+	autoCallout *autoCallout // for leftmost-longest matching
+}
+
+// Limits holds the resource limits that are applied while matching.
+// A zero value in any field means that no limit is set at that level,
+// and the next level (or ultimately the PCRE2 default) is used.
+type Limits struct {
+	// Match limits the number of times the internal match function
+	// is called, which bounds the amount of backtracking
+	Match uint32
+	// Depth limits the backtracking depth
+	Depth uint32
+	// Heap limits the amount of heap memory, in kibibytes, used to
+	// hold backtracking information
+	Heap uint32
 }
 
+// Option is a bitmask of PCRE2 compile options. Options may be
+// OR'ed together and passed to CompileWithOptions
+type Option uint32
+
+// SubstituteOptions is a bitmask of options for Regexp.Substitute
+type SubstituteOptions uint32
+
+// InvalidUTF8Mode specifies how subjects that are not valid UTF-8 are
+// handled when matching
+type InvalidUTF8Mode int
+
+// Newline is a newline convention, which determines the characters
+// that are matched by ^, $ and . in relation to line endings
+type Newline int
+
+// BSR specifies the characters that are matched by \R
+type BSR int
+
+// PartialMode selects the kind of partial matching that is done by
+// Regexp.MatchPartial and Regexp.FindPartial
+type PartialMode uint32
+
+// PartialStatus tells whether a partial match attempt found a full
+// match, a partial match or no match at all
+type PartialStatus int
+
+// DFAOption is a bitmask of options for DFA matching. See
+// Regexp.DFAMatch
+type DFAOption uint32
+
+// JITOption is a bitmask of modes for which the pattern is JIT compiled
+type JITOption uint32
+
 var (
 	// ErrInvalidRegexp is returned when the provided Regexp is
 	// not backed by a proper C pointer to pcre2_code
 	ErrInvalidRegexp = errors.New("invalid regexp")
-	// ErrInvalidUTF8String is returned when the input string cannot
-	// be decoded into runes
+	// ErrInvalidUTF8String is returned when the pattern or the
+	// replacement string is not valid UTF-8. Matching against a subject
+	// that is not valid UTF-8 fails with a MatchError that satisfies
+	// errors.Is(err, ErrInvalidUTF8String)
 	ErrInvalidUTF8String = errors.New("invalid utf8 string")
+	// ErrJITUnsupported is returned when JIT compilation is requested,
+	// but the PCRE2 library was built without JIT support
+	ErrJITUnsupported = errors.New("JIT is not supported by this PCRE2 library")
+	// ErrInvalidJITStackSize is returned when the requested JIT stack
+	// sizes are not positive, or the maximum is less than the start size
+	ErrInvalidJITStackSize = errors.New("invalid JIT stack size")
+	// ErrMatchLimit matches a MatchError reporting that the match
+	// limit was exceeded
+	ErrMatchLimit = errors.New("match limit exceeded")
+	// ErrDepthLimit matches a MatchError reporting that the depth
+	// limit was exceeded
+	ErrDepthLimit = errors.New("depth limit exceeded")
+	// ErrHeapLimit matches a MatchError reporting that the heap limit
+	// was exceeded
+	ErrHeapLimit = errors.New("heap limit exceeded")
+	// ErrCalloutAbort matches a MatchError reporting that a callout
+	// function aborted the match by returning CalloutAbort
+	ErrCalloutAbort = errors.New("match aborted by callout")
+	// ErrInvalidPartialMode is returned when the mode given for partial
+	// matching is neither PartialSoft nor PartialHard
+	ErrInvalidPartialMode = errors.New("invalid partial match mode")
+	// ErrDFAUnsupported matches a MatchError reporting that the
+	// pattern uses a feature that DFA matching does not support, such
+	// as a back reference
+	ErrDFAUnsupported = errors.New("pattern is not supported by DFA matching")
+	// ErrPartialMatch is returned by DFA matching when only a partial
+	// match was found
+	ErrPartialMatch = errors.New("partial match")
+	// ErrInvalidSerializedData is returned by DeserializeRegexps when
+	// the data was not produced by SerializeRegexps, or is truncated
+	ErrInvalidSerializedData = errors.New("invalid serialized regexp data")
 )
 
 // ErrCompile is returned when compiling the regular expression fails.
@@ -24,3 +124,184 @@ type ErrCompile struct {
 	offset  int
 	pattern string
 }
+
+// ErrJIT is returned when JIT compiling the regular expression fails.
+type ErrJIT struct {
+	code    int
+	message string
+}
+
+// ErrSerialize is returned when PCRE2 fails to serialize or
+// deserialize compiled patterns.
+type ErrSerialize struct {
+	code    int
+	message string
+}
+
+// ErrIncompatibleSerializedData is returned by DeserializeRegexps when
+// the data was produced by a PCRE2 library that is incompatible with
+// the one in use, such as a different major or minor version, or a
+// different code unit width.
+type ErrIncompatibleSerializedData struct {
+	version       string
+	codeUnitWidth int
+}
+
+// Info describes a compiled pattern, as reported by Regexp.Info.
+// Lengths are counted in characters, not bytes, unless the pattern was
+// compiled with NeverUTF.
+type Info struct {
+	// CaptureCount is the number of capturing groups
+	CaptureCount int
+	// BackRefMax is the number of the highest group that is referred
+	// to by a back reference, or 0 if there are no back references
+	BackRefMax int
+	// MinLength is a lower bound for the length of a subject that can
+	// match. It is 0 if no such bound could be worked out
+	MinLength int
+	// MaxLookbehind is the number of characters that a lookbehind may
+	// look back from the current position
+	MaxLookbehind int
+	// FirstCodeUnit is the byte that every match must start with, or
+	// -1 if there is no such byte. For caseless patterns, the byte in
+	// the other case may start a match as well
+	FirstCodeUnit int
+	// FirstCodeUnits holds every byte that a match can start with, in
+	// ascending order. It is nil if PCRE2 did not work out such a set
+	FirstCodeUnits []byte
+	// StartOfLine is true if a match can only start at the beginning
+	// of the subject or after a newline
+	StartOfLine bool
+	// LastCodeUnit is the rightmost byte that every match must
+	// contain, or -1 if there is no such byte. For caseless patterns,
+	// the byte in the other case may appear instead
+	LastCodeUnit int
+	// HasBackslashC is true if the pattern contains \C
+	HasBackslashC bool
+	// HasCRorLF is true if the pattern explicitly matches CR or LF
+	HasCRorLF bool
+	// JITSize is the size in bytes of the JIT compiled code, or 0 if
+	// the pattern has not been JIT compiled
+	JITSize int
+	// Size is the size in bytes of the compiled pattern
+	Size int
+	// Limits holds the limits that are set by the pattern itself with
+	// (*LIMIT_MATCH=...) and friends. Fields are zero if not set
+	Limits Limits
+	// Newline is the newline convention of the pattern
+	Newline Newline
+	// BSR is what \R matches in the pattern
+	BSR BSR
+}
+
+// PartialResult is the result of Regexp.MatchPartial and
+// Regexp.FindPartial
+type PartialResult struct {
+	// Status is FullMatch, PartialMatch or NoMatch
+	Status PartialStatus
+	// Index holds the byte offsets of the match, in the same format
+	// as FindIndex for MatchPartial, and as FindSubmatchIndex for
+	// FindPartial. For a partial match, the first pair spans from the
+	// start of the partial match to the end of the subject, and groups
+	// are reported as -1. Index is nil if there is no match.
+	Index []int
+	// Keep is the offset of the earliest byte in the subject that may
+	// be needed to complete a partial match once more input has been
+	// appended. It is -1 unless Status is PartialMatch.
+	Keep int
+}
+
+// Callout describes the state of the match when a callout, such as
+// (?C1) or (?C"name"), is reached. All offsets into the subject are
+// byte offsets.
+type Callout struct {
+	// Number is the number of a numerical callout, or 0 for a
+	// callout with a string argument
+	Number int
+	// String is the argument of a string callout
+	String string
+	// Mark is the most recently passed (*MARK) name, if any
+	Mark string
+	// StartMatch is the offset where the current match attempt started
+	StartMatch int
+	// SubjectPosition is the current position in the subject
+	SubjectPosition int
+	// PatternPosition is the offset into the pattern of the next item
+	// to be matched
+	PatternPosition int
+	// Captures holds pairs of offsets for the groups that have been
+	// captured so far, in the same format as FindSubmatchIndex. The
+	// first pair spans from StartMatch to SubjectPosition. Groups
+	// that are unset are reported as -1.
+	Captures []int
+}
+
+// CalloutAction is returned from a CalloutFunc to tell PCRE2 how
+// to proceed
+type CalloutAction int
+
+// CalloutFunc is the type of functions that can be registered with
+// Regexp.SetCallout
+type CalloutFunc func(*Callout) CalloutAction
+
+// ErrSubstitute is returned when the replacement string given to
+// Regexp.Substitute is invalid.
+type ErrSubstitute struct {
+	code    int
+	offset  int
+	message string
+}
+
+// MatchError is returned when PCRE2 fails while matching, as opposed
+// to simply not finding a match. Use errors.Is with sentinels such as
+// ErrMatchLimit or ErrInvalidUTF8String to check for specific causes.
+type MatchError struct {
+	code    int
+	message string
+}
+
+// Matcher matches against a Regexp using match data and result buffers
+// of its own, which are reused across calls. Unlike a Regexp, a Matcher
+// must not be used by multiple goroutines at the same time.
+type Matcher struct {
+	regexp    *Regexp
+	matchData *matchData
+	indices   []int
+}
+
+// Scanner finds successive matches of a Regexp in a stream. Only the
+// part of the stream that may still be needed is kept in memory. See
+// Regexp.NewScanner
+type Scanner struct {
+	regexp  *Regexp
+	reader  io.Reader
+	buf     []byte // unconsumed input, starting at offset base
+	base    int64
+	pos     int64 // where the next match attempt starts
+	prevEnd int64 // end of the previous match, or -1
+	skip    bool  // move on by one character, after an empty match
+	maxSize int
+	eof     bool
+	done    bool
+	err     error
+	index   []int64 // offsets of the current match and its groups
+}
+
+// DFAWorkspace holds the working space of DFA matching. After a
+// partial match, it holds the state that is needed to continue
+// matching with DFARestart. See Regexp.NewDFAWorkspace
+type DFAWorkspace struct {
+	regexp  *Regexp
+	ws      []int32 // int in C
+	partial bool    // the last match was partial, so it can be restarted
+}
+
+// Match represents a single match of a Regexp against a subject.
+// The text of each group is only extracted when it is accessed.
+type Match struct {
+	regexp  *Regexp
+	bytes   []byte // subject, if the match was against a []byte
+	str     string // subject, if the match was against a string
+	ovector []int  // byte offsets in the subject, -1 if unset
+	mark    string
+}
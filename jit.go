@@ -0,0 +1,153 @@
+package pcre2
+
+/*
+#define PCRE2_CODE_UNIT_WIDTH 8
+#include <pcre2.h>
+*/
+import "C"
+import (
+	"sync"
+	"unsafe"
+)
+
+// JIT modes. These may be OR'ed together and passed to Regexp.JIT
+const (
+	JITComplete    JITOption = C.PCRE2_JIT_COMPLETE
+	JITPartialSoft JITOption = C.PCRE2_JIT_PARTIAL_SOFT
+	JITPartialHard JITOption = C.PCRE2_JIT_PARTIAL_HARD
+)
+
+// JITSupported returns true if the underlying PCRE2 library was built
+// with JIT support
+func JITSupported() bool {
+	var i C.uint32_t
+	if C.pcre2_config(C.PCRE2_CONFIG_JIT, unsafe.Pointer(&i)) < 0 {
+		return false
+	}
+	return i == 1
+}
+
+// JIT compiles the pattern into native machine code for the given
+// modes. If no modes are given, JITComplete is assumed.
+//
+// JIT compilation is an optimization only: if it fails, or if the
+// PCRE2 library does not support it, matching transparently falls back
+// to the interpreter. The returned error tells you whether JIT
+// compilation actually succeeded.
+//
+// JIT waits for matches that are in progress to finish, so it is best
+// called before the Regexp is shared between goroutines.
+func (r *Regexp) JIT(modes ...JITOption) error {
+	rptr, err := r.lock()
+	if err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	var flags JITOption
+	for _, m := range modes {
+		flags |= m
+	}
+	if flags == 0 {
+		flags = JITComplete
+	}
+
+	return r.jitCompile(rptr, flags)
+}
+
+// jitCompile JIT compiles rptr for the given modes. The caller must
+// have locked rptr.
+func (r *Regexp) jitCompile(rptr *C.pcre2_code, flags JITOption) error {
+	if !JITSupported() {
+		return ErrJITUnsupported
+	}
+
+	if rc := C.pcre2_jit_compile(rptr, C.uint32_t(flags)); rc != 0 {
+		return ErrJIT{code: int(rc), message: errorMessage(rc)}
+	}
+	r.jit |= flags
+	return nil
+}
+
+// JITCompiled returns true if the pattern has been successfully JIT
+// compiled for the given mode
+func (r *Regexp) JITCompiled(mode JITOption) bool {
+	if _, err := r.acquire(); err != nil {
+		return false
+	}
+	defer r.release()
+
+	return r.jit&mode == mode
+}
+
+// SetJITStackSize configures the size of the JIT stacks that are used
+// when matching against this Regexp. By default PCRE2 uses 32K of the
+// machine stack, which may not be enough for complicated patterns.
+//
+// Each concurrent match checks out a JIT stack of its own, so the same
+// Regexp may be used from many goroutines. The stacks are released
+// when the Regexp is freed.
+func (r *Regexp) SetJITStackSize(startSize, maxSize int) error {
+	if _, err := r.lock(); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	if startSize <= 0 || maxSize < startSize {
+		return ErrInvalidJITStackSize
+	}
+
+	old := r.jitStacks
+	r.jitStacks = &jitStackPool{
+		startSize: C.PCRE2_SIZE(startSize),
+		maxSize:   C.PCRE2_SIZE(maxSize),
+	}
+	if old != nil {
+		old.free()
+	}
+	return nil
+}
+
+// jitStackPool keeps a list of idle JIT stacks. A JIT stack can only be
+// used by one match at a time, so each in-flight match gets its own.
+type jitStackPool struct {
+	mu        sync.Mutex
+	closed    bool
+	startSize C.PCRE2_SIZE
+	maxSize   C.PCRE2_SIZE
+	idle      []*C.pcre2_jit_stack
+}
+
+func (p *jitStackPool) get() *C.pcre2_jit_stack {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if l := len(p.idle); l > 0 {
+		s := p.idle[l-1]
+		p.idle = p.idle[:l-1]
+		return s
+	}
+	return C.pcre2_jit_stack_create(p.startSize, p.maxSize, nil)
+}
+
+func (p *jitStackPool) put(s *C.pcre2_jit_stack) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		C.pcre2_jit_stack_free(s)
+		return
+	}
+	p.idle = append(p.idle, s)
+}
+
+func (p *jitStackPool) free() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, s := range p.idle {
+		C.pcre2_jit_stack_free(s)
+	}
+	p.idle = nil
+	p.closed = true
+}
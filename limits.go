@@ -0,0 +1,142 @@
+package pcre2
+
+/*
+#define PCRE2_CODE_UNIT_WIDTH 8
+#include <pcre2.h>
+*/
+import "C"
+import (
+	"context"
+	"sync/atomic"
+)
+
+var defaultLimits atomic.Value
+
+func init() {
+	defaultLimits.Store(Limits{})
+}
+
+// SetDefaultLimits sets the limits that are applied to every match,
+// unless they are overridden by Regexp.SetLimits or by the limits
+// passed to one of the *WithLimits methods.
+func SetDefaultLimits(l Limits) {
+	defaultLimits.Store(l)
+}
+
+// DefaultLimits returns the package-wide default limits
+func DefaultLimits() Limits {
+	return defaultLimits.Load().(Limits)
+}
+
+// SetLimits sets the limits that are applied when matching against
+// this Regexp. Fields that are zero fall back to the package-wide
+// defaults set by SetDefaultLimits.
+//
+// SetLimits waits for matches that are in progress to finish, so it is
+// best called before the Regexp is shared between goroutines.
+func (r *Regexp) SetLimits(l Limits) {
+	if _, err := r.lock(); err != nil {
+		return
+	}
+	defer r.unlock()
+
+	r.limits = l
+}
+
+// Limits returns the limits set by SetLimits
+func (r *Regexp) Limits() Limits {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.limits
+}
+
+// effectiveLimits merges the limits for a single call with the limits
+// for this Regexp and the package-wide defaults. Non-zero values take
+// precedence in that order.
+func (r *Regexp) effectiveLimits(l *Limits) Limits {
+	ret := DefaultLimits()
+	ret.merge(r.limits)
+	if l != nil {
+		ret.merge(*l)
+	}
+	return ret
+}
+
+func (l *Limits) merge(other Limits) {
+	if other.Match > 0 {
+		l.Match = other.Match
+	}
+	if other.Depth > 0 {
+		l.Depth = other.Depth
+	}
+	if other.Heap > 0 {
+		l.Heap = other.Heap
+	}
+}
+
+func (l Limits) apply(matchContext *C.pcre2_match_context) {
+	if l.Match > 0 {
+		C.pcre2_set_match_limit(matchContext, C.uint32_t(l.Match))
+	}
+	if l.Depth > 0 {
+		C.pcre2_set_depth_limit(matchContext, C.uint32_t(l.Depth))
+	}
+	if l.Heap > 0 {
+		C.pcre2_set_heap_limit(matchContext, C.uint32_t(l.Heap))
+	}
+}
+
+// matchError converts the return value from pcre2_match to an error.
+// Successful matches and PCRE2_ERROR_NOMATCH are not errors.
+func matchError(rc int) error {
+	// PCRE2_ERROR_PARTIAL is only returned when partial matching was
+	// requested, and is not a failure
+	if rc >= 0 || rc == C.PCRE2_ERROR_NOMATCH || rc == C.PCRE2_ERROR_PARTIAL {
+		return nil
+	}
+	return MatchError{code: rc, message: errorMessage(C.int(rc))}
+}
+
+// MatchWithLimits is like Match, but applies the given limits and
+// reports errors such as exceeding the match limit instead of treating
+// them as a failed match. Use errors.Is(err, ErrMatchLimit) and so on
+// to tell which limit was exceeded.
+func (r *Regexp) MatchWithLimits(b []byte, l Limits) (bool, error) {
+	return r.matchWithLimits(context.Background(), b, &l)
+}
+
+// MatchStringWithLimits is like MatchString, but applies the given
+// limits and reports errors such as ErrMatchLimit instead of treating
+// them as a failed match.
+func (r *Regexp) MatchStringWithLimits(s string, l Limits) (bool, error) {
+	return r.matchWithLimits(context.Background(), stringBytes(s), &l)
+}
+
+func (r *Regexp) matchWithLimits(ctx context.Context, subject []byte, l *Limits) (bool, error) {
+	rptr, err := r.acquire()
+	if err != nil {
+		return false, err
+	}
+	defer r.release()
+
+	subject, _ = r.prepareSubject(subject)
+	rc, err := r.matchSubjectContext(ctx, rptr, subject, 0, 0, nil, l)
+	if err != nil {
+		return false, err
+	}
+	return rc >= 0, nil
+}
+
+// FindAllIndexWithLimits is like FindAllIndex, but applies the given
+// limits. If an error occurs, the matches found so far are returned
+// along with the error.
+func (r *Regexp) FindAllIndexWithLimits(b []byte, n int, l Limits) ([][]int, error) {
+	return r.findAllIndex(context.Background(), b, n, &l)
+}
+
+// FindAllStringIndexWithLimits is like FindAllStringIndex, but applies
+// the given limits. If an error occurs, the matches found so far are
+// returned along with the error.
+func (r *Regexp) FindAllStringIndexWithLimits(s string, n int, l Limits) ([][]int, error) {
+	return r.findAllIndex(context.Background(), stringBytes(s), n, &l)
+}
@@ -0,0 +1,365 @@
+package pcre2
+
+/*
+#define PCRE2_CODE_UNIT_WIDTH 8
+#include <stdint.h>
+#include <stdlib.h>
+#include <pcre2.h>
+
+extern int goCallout(pcre2_callout_block *, uintptr_t);
+
+// MY_longest is the callout data for a match against the pattern that
+// was compiled with automatic callouts. The callout at the end of the
+// pattern is reached whenever a match is about to succeed.
+typedef struct {
+	uintptr_t handle;       // the Go callout function, or 0 if none
+	PCRE2_SIZE pattern_end; // the position of the callout at the end
+	PCRE2_SIZE end;         // where the match must end, or PCRE2_UNSET
+	PCRE2_SIZE offset;      // the start offset of the match
+	uint32_t options;       // the options of the match
+	int guards;             // whether {} callouts are loop guards
+	int found;              // set once a match has been found
+	PCRE2_SIZE start;       // the start of the longest match found
+	PCRE2_SIZE longest;     // the end of the longest match found
+} MY_longest;
+
+// MY_guard_group parses the next group number in a loop guard
+static
+uint32_t
+MY_guard_group(PCRE2_SPTR *p, PCRE2_SPTR end) {
+	uint32_t n = 0;
+	while (*p < end && **p == ' ') {
+		(*p)++;
+	}
+	while (*p < end && **p >= '0' && **p <= '9') {
+		n = n * 10 + (**p - '0');
+		(*p)++;
+	}
+	return n;
+}
+
+static
+int
+MY_longest_callout(pcre2_callout_block *block, void *data) {
+	MY_longest *l = data;
+
+	if (block->callout_string != NULL && l->guards && block->callout_string[-1] == '{') {
+		// A loop guard {i l} fails an iteration that is empty, unless
+		// it starts where the loop started. Group i holds the
+		// iteration, and group l is empty at the start of the loop
+		PCRE2_SPTR p = block->callout_string;
+		PCRE2_SPTR end = p + block->callout_string_length;
+		uint32_t i = MY_guard_group(&p, end);
+		uint32_t loop = MY_guard_group(&p, end);
+		PCRE2_SIZE *ovector = block->offset_vector;
+		if (i < block->capture_top && loop < block->capture_top &&
+			ovector[2*i] == ovector[2*i+1] && ovector[2*i] != ovector[2*loop]) {
+			return 1;
+		}
+		return 0;
+	}
+
+	// Callouts that appear in the pattern itself go to Go
+	if (block->callout_number != 255 || block->callout_string != NULL) {
+		return l->handle != 0 ? goCallout(block, l->handle) : 0;
+	}
+	if (block->pattern_position != l->pattern_end) {
+		return 0;
+	}
+
+	// Empty matches that the options forbid are rejected after the
+	// callout, so they must not be counted
+	PCRE2_SIZE pos = block->current_position;
+	if (pos == block->start_match &&
+		((l->options & PCRE2_NOTEMPTY) != 0 ||
+		((l->options & PCRE2_NOTEMPTY_ATSTART) != 0 && pos == l->offset))) {
+		return 1;
+	}
+
+	if (l->end != PCRE2_UNSET) {
+		return pos == l->end ? 0 : 1;
+	}
+
+	// Try every way of matching at the leftmost position where there
+	// is a match, and stop once the next position is tried
+	if (l->found && block->start_match != l->start) {
+		return PCRE2_ERROR_NOMATCH;
+	}
+	if (!l->found || pos > l->longest) {
+		l->found = 1;
+		l->start = block->start_match;
+		l->longest = pos;
+	}
+	return 1;
+}
+
+static
+void
+MY_pcre2_set_longest_callout(pcre2_match_context *mcontext, MY_longest *l) {
+	pcre2_set_callout(mcontext, MY_longest_callout, l);
+}
+*/
+import "C"
+import (
+	"context"
+	"runtime/cgo"
+	"strings"
+	"unsafe"
+)
+
+// Longest makes future searches prefer leftmost-longest matches. That
+// is, when matching against text, the regexp returns a match that
+// begins as early as possible in the input (leftmost), and among those
+// it chooses a match that is as long as possible. Among the matches
+// of that length, the groups are those that the usual backtracking
+// search would find first, just as in the regexp package.
+//
+// The longest match is found with PCRE2's DFA matcher, which is never
+// JIT compiled, and the groups with a second, anchored match. Patterns
+// that the DFA matcher does not support, such as those that contain
+// back references, and subjects in InvalidUTF8Skip mode are searched
+// by trying every way in which the pattern can match instead, which
+// may be much slower. (*ACCEPT) ends such a search early. If the
+// second pattern cannot be compiled, the methods that need it return
+// the compile error.
+//
+// The methods that find matches, such as Find, FindAll, ReplaceAll,
+// MatchPartial and NewScanner, all follow the leftmost-longest rule.
+// Substitute and DFAMatch are not affected. Callout functions are
+// called by both matches, and so may be called more than once for the
+// same position.
+//
+// Like SetInvalidUTF8Mode, Longest waits for matches that are in
+// progress to finish, so it is best called before the Regexp is shared
+// between goroutines.
+func (r *Regexp) Longest() {
+	rptr, err := r.lock()
+	if err != nil {
+		return
+	}
+	defer r.unlock()
+
+	if r.longest {
+		return
+	}
+	r.longest = true
+	// If the second pattern does not compile, the error is kept and
+	// reported by every match that needs it
+	_ = r.compileAutoCallout(rptr)
+}
+
+// autoCallout is the pattern of a Regexp in leftmost-longest mode,
+// compiled with automatic callouts
+type autoCallout struct {
+	code *C.pcre2_code
+	// err is the compile error if code could not be compiled
+	err error
+	// end is the pattern position of the callout at the end
+	end int
+	// groups holds the group of the Regexp for each group of code, or
+	// -1 for groups that were added by loop guards. It is nil if the
+	// groups are the same.
+	groups []int
+}
+
+// compileAutoCallout compiles the pattern of rptr again with automatic
+// callouts, which matchLongest uses to find the groups of the longest
+// match. If that fails, the error is kept for matchLongest to return.
+// The caller must have locked the Regexp.
+func (r *Regexp) compileAutoCallout(rptr *C.pcre2_code) error {
+	var flags C.uint32_t
+	C.pcre2_pattern_info(rptr, C.PCRE2_INFO_ARGOPTIONS, unsafe.Pointer(&flags))
+
+	pattern := r.pattern
+	var groups []int
+	if r.posix {
+		pattern, groups = r.posixPattern(true)
+	}
+
+	// The pattern is wrapped in a group, as the callout at the end of a
+	// top level alternative is not at the end of the pattern. If the
+	// pattern ends in a comment, the group is closed on the next line.
+	// If neither compiles, the unwrapped pattern is used
+	r.freeAutoCallout()
+	var err error
+	for _, p := range []string{wrapPattern(pattern, ")"), wrapPattern(pattern, "\n)"), pattern} {
+		var re *C.pcre2_code
+		re, err = compile(p, Option(flags|C.PCRE2_AUTO_CALLOUT))
+		if err == nil {
+			r.autoCallout = &autoCallout{code: re, end: len(p), groups: groups}
+			return nil
+		}
+	}
+	r.autoCallout = &autoCallout{err: err}
+	return err
+}
+
+// wrapPattern puts pattern in a non-capturing group that is closed by
+// end. Settings such as (*UTF) must come first, so they are kept in
+// front of the group. \E ends a \Q that is still open at the end of the
+// pattern, and is ignored otherwise.
+func wrapPattern(pattern string, end string) string {
+	i := 0
+	for strings.HasPrefix(pattern[i:], "(*") {
+		n := strings.IndexByte(pattern[i:], ')')
+		if n < 0 || !isPatternSetting(pattern[i+2:i+n]) {
+			break
+		}
+		i += n + 1
+	}
+	return pattern[:i] + "(?:" + pattern[i:] + "\\E" + end
+}
+
+// isPatternSetting returns true if (*name) sets an option, rather than
+// being a backtracking control verb
+func isPatternSetting(name string) bool {
+	switch name {
+	case "", "ACCEPT", "FAIL", "F", "COMMIT", "PRUNE", "SKIP", "THEN":
+		return false
+	}
+	for _, c := range name {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '_' && c != '=' {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Regexp) freeAutoCallout() {
+	if r.autoCallout != nil {
+		C.pcre2_code_free(r.autoCallout.code)
+		r.autoCallout = nil
+	}
+}
+
+// matchLongest is matchSubject for Regexps in leftmost-longest mode.
+// The leftmost-longest match is found by the DFA matcher, which leaves
+// it in the first pair of the ovector. If there are groups, the match
+// is done again with automatic callouts that reject every match that
+// does not end at the same position, so that the groups are set as
+// the backtracking matcher sets them.
+func (r *Regexp) matchLongest(ctx context.Context, rptr *C.pcre2_code, subject []byte, offset int, options int, matchData *C.pcre2_match_data, limits *Limits) (int, error) {
+	l := r.effectiveLimits(limits)
+	callout := r.newCalloutState(ctx)
+	matchContext, release := r.newMatchContext(l, callout)
+	defer release()
+
+	ws := make([]int32, dfaWorkspaceSize)
+	rc := dfaMatch(rptr, subject, offset, C.uint32_t(options), matchData, matchContext, ws)
+	for rc == C.PCRE2_ERROR_DFA_WSSIZE && len(ws) < dfaMaxWorkspaceSize {
+		ws = make([]int32, 2*len(ws))
+		rc = dfaMatch(rptr, subject, offset, C.uint32_t(options), matchData, matchContext, ws)
+	}
+	callout.rethrow()
+
+	var start, end int
+	switch {
+	case rc >= 0:
+		// If there are more matches than fit in the ovector, the
+		// result is 0, but the longest match still comes first
+		if r.subexps == 0 {
+			return 1, nil
+		}
+		ovector := pcre2GetOvectorPointer(matchData, 1)
+		start, end = int(ovector[0]), int(ovector[1])
+	case MatchError{code: rc}.Is(ErrDFAUnsupported):
+		if err := r.autoCallout.err; err != nil {
+			return -1, err
+		}
+		var found C.MY_longest
+		rc, found = r.matchAutoCallout(subject, offset, options, -1, matchData, l, callout)
+		switch {
+		case rc == C.PCRE2_ERROR_PARTIAL && (found.found == 0 || int(pcre2GetOvectorPointer(matchData, 1)[0]) <= int(found.start)):
+			return rc, nil
+		case found.found == 0:
+			// Either there is no match, or it was ended by (*ACCEPT)
+			return rc, nil
+		}
+		start, end = int(found.start), int(found.longest)
+	default:
+		return rc, nil
+	}
+
+	if err := r.autoCallout.err; err != nil {
+		return -1, err
+	}
+	// A match that is found by the DFA matcher is complete, so it does
+	// not depend on partial matching. The empty match at start is only
+	// forbidden by PCRE2_NOTEMPTY_ATSTART if start is the start offset
+	options &^= C.PCRE2_PARTIAL_SOFT | C.PCRE2_PARTIAL_HARD
+	if start != offset {
+		options &^= C.PCRE2_NOTEMPTY_ATSTART
+	}
+	rc, _ = r.matchAutoCallout(subject, start, options|C.PCRE2_ANCHORED, end, matchData, l, callout)
+	return rc, nil
+}
+
+// matchAutoCallout matches the pattern that was compiled with automatic
+// callouts. If end >= 0, only a match that ends there is accepted.
+// Otherwise every match is rejected, and the start and end of the
+// longest match at the leftmost position are returned in the callout
+// data. The groups are left in matchData as those of the Regexp.
+func (r *Regexp) matchAutoCallout(subject []byte, offset int, options int, end int, matchData *C.pcre2_match_data, limits Limits, callout *calloutState) (int, C.MY_longest) {
+	ac := r.autoCallout
+	matchContext := C.pcre2_match_context_create(nil)
+	defer C.pcre2_match_context_free(matchContext)
+	limits.apply(matchContext)
+
+	// The callout data is kept in C memory, as PCRE2 holds on to it
+	data := (*C.MY_longest)(C.calloc(1, C.sizeof_MY_longest))
+	defer C.free(unsafe.Pointer(data))
+	if callout != nil {
+		handle := cgo.NewHandle(callout)
+		defer handle.Delete()
+		data.handle = C.uintptr_t(handle)
+	}
+	data.pattern_end = C.PCRE2_SIZE(ac.end)
+	data.end = C.PCRE2_UNSET
+	if end >= 0 {
+		data.end = C.PCRE2_SIZE(end)
+	}
+	data.offset = C.PCRE2_SIZE(offset)
+	data.options = C.uint32_t(options)
+	if ac.groups != nil {
+		data.guards = 1
+	}
+	C.MY_pcre2_set_longest_callout(matchContext, data)
+
+	md := matchData
+	if ac.groups != nil {
+		md = C.pcre2_match_data_create_from_pattern(ac.code, nil)
+		defer C.pcre2_match_data_free(md)
+	}
+	rc := int(C.pcre2_match(
+		ac.code,
+		subjectPtr(subject),
+		C.size_t(len(subject)),
+		C.PCRE2_SIZE(offset),
+		C.uint32_t(options),
+		md,
+		matchContext,
+	))
+	callout.rethrow()
+
+	if ac.groups != nil && (rc > 0 || rc == C.PCRE2_ERROR_PARTIAL) {
+		// Leave out the groups that loop guards added
+		from := pcre2GetOvectorPointer(md, len(ac.groups))
+		to := pcre2GetOvectorPointer(matchData, r.subexps+1)
+		for i := range to {
+			to[i] = C.PCRE2_UNSET
+		}
+		if rc > 0 {
+			rc = 1
+		}
+		for i, g := range ac.groups {
+			if g < 0 || from[2*i] == C.PCRE2_UNSET || (rc < 0 && g > 0) {
+				continue
+			}
+			to[2*g], to[2*g+1] = from[2*i], from[2*i+1]
+			if rc > 0 && g >= rc {
+				rc = g + 1
+			}
+		}
+	}
+	return rc, *data
+}
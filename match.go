@@ -0,0 +1,141 @@
+package pcre2
+
+/*
+#define PCRE2_CODE_UNIT_WIDTH 8
+#include <pcre2.h>
+*/
+import "C"
+import "context"
+
+// newMatch captures the state of matchData, so that it can be
+// inspected after the match data has been reused or released
+func (r *Regexp) newMatch(matchData *C.pcre2_match_data, howmany int, repl utf8Replacements) *Match {
+	return &Match{
+		regexp:  r,
+		ovector: ovectorToIndex(pcre2GetOvectorPointer(matchData, howmany), howmany, repl),
+		mark:    zeroTerminatedString(C.pcre2_get_mark(matchData)),
+	}
+}
+
+func (r *Regexp) findAllMatches(subject []byte, n int) []*Match {
+	howmany := r.NumSubexp() + 1
+	out := []*Match(nil)
+	r.findAll(context.Background(), subject, n, nil, func(matchData *C.pcre2_match_data, repl utf8Replacements) {
+		out = append(out, r.newMatch(matchData, howmany, repl))
+	})
+	return out
+}
+
+// FindMatch returns a Match holding the leftmost match of the Regexp
+// in b, or nil if there is no match.
+func (r *Regexp) FindMatch(b []byte) *Match {
+	all := r.FindAllMatches(b, 1)
+	if len(all) != 1 {
+		return nil
+	}
+	return all[0]
+}
+
+// FindStringMatch returns a Match holding the leftmost match of the
+// Regexp in s, or nil if there is no match.
+func (r *Regexp) FindStringMatch(s string) *Match {
+	all := r.FindAllStringMatches(s, 1)
+	if len(all) != 1 {
+		return nil
+	}
+	return all[0]
+}
+
+// FindAllMatches returns a slice of successive matches of the Regexp
+// in b. If n >= 0, at most n matches are returned.
+func (r *Regexp) FindAllMatches(b []byte, n int) []*Match {
+	all := r.findAllMatches(b, n)
+	for _, m := range all {
+		m.bytes = b
+	}
+	return all
+}
+
+// FindAllStringMatches returns a slice of successive matches of the
+// Regexp in s. If n >= 0, at most n matches are returned.
+func (r *Regexp) FindAllStringMatches(s string, n int) []*Match {
+	all := r.findAllMatches(stringBytes(s), n)
+	for _, m := range all {
+		m.str = s
+	}
+	return all
+}
+
+// GroupIndex returns the byte offsets of the text matched by group i
+// as a pair of integers, or nil if the group did not participate in
+// the match. Group 0 is the whole match.
+func (m *Match) GroupIndex(i int) []int {
+	if m.Unset(i) {
+		return nil
+	}
+
+	return []int{m.ovector[2*i], m.ovector[2*i+1]}
+}
+
+// Group returns the text matched by group i, or the empty string if
+// the group did not participate in the match. Group 0 is the whole
+// match.
+func (m *Match) Group(i int) string {
+	is := m.GroupIndex(i)
+	if is == nil {
+		return ""
+	}
+
+	if m.bytes != nil {
+		return string(m.bytes[is[0]:is[1]])
+	}
+	return m.str[is[0]:is[1]]
+}
+
+// Unset returns true if group i did not participate in the match, or
+// if there is no such group.
+func (m *Match) Unset(i int) bool {
+	return i < 0 || 2*i >= len(m.ovector) || m.ovector[2*i] < 0
+}
+
+// Start returns the byte offset of the start of the match
+func (m *Match) Start() int {
+	return m.GroupIndex(0)[0]
+}
+
+// End returns the byte offset of the end of the match
+func (m *Match) End() int {
+	return m.GroupIndex(0)[1]
+}
+
+// Mark returns the name of the last (*MARK) that was passed on the
+// matching path, or the empty string if there was none.
+func (m *Match) Mark() string {
+	return m.mark
+}
+
+// Named returns the text of the group with the given name. If the
+// pattern contains more than one group with the same name, the first
+// of them that participated in the match is used, as PCRE2's
+// pcre2_substring_get_byname does. If no group of that name was set,
+// the empty string is returned.
+func (m *Match) Named(name string) string {
+	for _, i := range m.regexp.SubexpIndices(name) {
+		if !m.Unset(i) {
+			return m.Group(i)
+		}
+	}
+	return ""
+}
+
+// NamedMap returns a map from each group name in the pattern to the
+// text it matched, as returned by Named.
+func (m *Match) NamedMap() map[string]string {
+	ret := make(map[string]string, len(m.regexp.names))
+	for _, e := range m.regexp.names {
+		if _, ok := ret[e.name]; !ok {
+			ret[e.name] = m.Named(e.name)
+		}
+	}
+	return ret
+}
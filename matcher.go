@@ -0,0 +1,163 @@
+package pcre2
+
+/*
+#define PCRE2_CODE_UNIT_WIDTH 8
+#include <pcre2.h>
+*/
+import "C"
+import (
+	"context"
+	"math/bits"
+	"runtime"
+	"sync"
+)
+
+// matchData wraps a pcre2_match_data, so that it can be kept in a
+// sync.Pool. The C memory is released by a finalizer once the pool
+// drops the wrapper.
+type matchData struct {
+	ptr *C.pcre2_match_data
+}
+
+// newMatchData creates match data with room for the given number of
+// pairs of offsets
+func newMatchData(pairs int) *matchData {
+	md := &matchData{ptr: C.pcre2_match_data_create(C.uint32_t(pairs), nil)}
+	runtime.SetFinalizer(md, (*matchData).free)
+	return md
+}
+
+func (md *matchData) free() {
+	runtime.SetFinalizer(md, nil)
+	C.pcre2_match_data_free(md.ptr)
+	md.ptr = nil
+}
+
+// matchDataPools holds match data that is shared by all Regexps. Pool
+// i holds match data with room for 1<<i pairs of offsets, which is
+// enough for the 65535 groups that PCRE2 allows.
+var matchDataPools [17]sync.Pool
+
+// matchDataPool returns the pool for match data with room for pairs
+// pairs of offsets, and the number of pairs that the pool's match
+// data has room for
+func matchDataPool(pairs int) (*sync.Pool, int) {
+	i := bits.Len(uint(pairs - 1))
+	return &matchDataPools[i], 1 << i
+}
+
+// getMatchData returns match data that is large enough for the groups
+// of the Regexp. The caller should return it with putMatchData once it
+// is done with it.
+func (r *Regexp) getMatchData() *matchData {
+	pool, pairs := matchDataPool(r.subexps + 1)
+	if md, ok := pool.Get().(*matchData); ok {
+		return md
+	}
+	return newMatchData(pairs)
+}
+
+func (r *Regexp) putMatchData(md *matchData) {
+	pool, _ := matchDataPool(r.subexps + 1)
+	pool.Put(md)
+}
+
+// NewMatcher returns a Matcher for this Regexp. The Matcher should be
+// released by calling Free once it is no longer needed.
+func (r *Regexp) NewMatcher() *Matcher {
+	return &Matcher{regexp: r}
+}
+
+// Free releases the match data that is owned by the Matcher. It is
+// safe to call Free more than once, and a Matcher that is garbage
+// collected without being freed is released by a finalizer.
+func (m *Matcher) Free() error {
+	if m.matchData != nil {
+		m.matchData.free()
+		m.matchData = nil
+	}
+	return nil
+}
+
+// Close is the same as Free. It allows a Matcher to be used as an
+// io.Closer
+func (m *Matcher) Close() error {
+	return m.Free()
+}
+
+// match runs a single match against subject using the Matcher's own
+// match data. If howmany is greater than zero, the offsets of the
+// first howmany groups are stored in m.indices.
+func (m *Matcher) match(subject []byte, howmany int) (bool, error) {
+	r := m.regexp
+	rptr, err := r.acquire()
+	if err != nil {
+		return false, err
+	}
+	defer r.release()
+
+	if m.matchData == nil {
+		m.matchData = newMatchData(r.subexps + 1)
+	}
+
+	subject, repl := r.prepareSubject(subject)
+	rc, err := r.matchSubjectContext(context.Background(), rptr, subject, 0, 0, m.matchData.ptr, nil)
+	if err != nil || rc < 0 {
+		return false, err
+	}
+
+	if howmany > 0 {
+		m.indices = m.indices[:0]
+		for _, v := range pcre2GetOvectorPointer(m.matchData.ptr, howmany) {
+			if v == C.PCRE2_UNSET {
+				m.indices = append(m.indices, -1)
+				continue
+			}
+			m.indices = append(m.indices, repl.originalOffset(int(v)))
+		}
+	}
+	return true, nil
+}
+
+// Match reports whether b contains any match of the Regexp
+func (m *Matcher) Match(b []byte) bool {
+	ok, _ := m.match(b, 0)
+	return ok
+}
+
+// MatchString reports whether s contains any match of the Regexp
+func (m *Matcher) MatchString(s string) bool {
+	ok, _ := m.match(stringBytes(s), 0)
+	return ok
+}
+
+// FindIndex is like Regexp.FindIndex. The returned slice is owned by
+// the Matcher, and is overwritten by the next call.
+func (m *Matcher) FindIndex(b []byte) []int {
+	if ok, _ := m.match(b, 1); !ok {
+		return nil
+	}
+	return m.indices
+}
+
+// FindStringIndex is like Regexp.FindStringIndex. The returned slice
+// is owned by the Matcher, and is overwritten by the next call.
+func (m *Matcher) FindStringIndex(s string) []int {
+	return m.FindIndex(stringBytes(s))
+}
+
+// FindSubmatchIndex is like Regexp.FindSubmatchIndex. The returned
+// slice is owned by the Matcher, and is overwritten by the next call.
+func (m *Matcher) FindSubmatchIndex(b []byte) []int {
+	if ok, _ := m.match(b, m.regexp.NumSubexp()+1); !ok {
+		return nil
+	}
+	return m.indices
+}
+
+// FindStringSubmatchIndex is like Regexp.FindStringSubmatchIndex. The
+// returned slice is owned by the Matcher, and is overwritten by the
+// next call.
+func (m *Matcher) FindStringSubmatchIndex(s string) []int {
+	return m.FindSubmatchIndex(stringBytes(s))
+}
@@ -0,0 +1,103 @@
+package pcre2
+
+/*
+#define PCRE2_CODE_UNIT_WIDTH 8
+#include <pcre2.h>
+*/
+import "C"
+import (
+	"bytes"
+	"sort"
+	"unsafe"
+)
+
+// nameEntry is a single entry in the pattern's name table
+type nameEntry struct {
+	name  string
+	group int
+}
+
+// NumSubexp returns the number of parenthesized subexpressions in
+// this Regexp. Like SubexpNames, it can still be called after Free.
+func (r *Regexp) NumSubexp() int {
+	return r.subexps
+}
+
+// captureCount returns the number of capturing groups in the
+// compiled pattern
+func captureCount(rptr *C.pcre2_code) int {
+	var i C.uint32_t
+	C.pcre2_pattern_info(rptr, C.PCRE2_INFO_CAPTURECOUNT, unsafe.Pointer(&i))
+	return int(i)
+}
+
+// nameTable reads the name table of the compiled pattern. Entries
+// are sorted by name.
+func nameTable(rptr *C.pcre2_code) []nameEntry {
+	var count, entrySize C.uint32_t
+	var table C.PCRE2_SPTR
+	C.pcre2_pattern_info(rptr, C.PCRE2_INFO_NAMECOUNT, unsafe.Pointer(&count))
+	if count == 0 {
+		return nil
+	}
+	C.pcre2_pattern_info(rptr, C.PCRE2_INFO_NAMEENTRYSIZE, unsafe.Pointer(&entrySize))
+	C.pcre2_pattern_info(rptr, C.PCRE2_INFO_NAMETABLE, unsafe.Pointer(&table))
+
+	// Each entry is entrySize bytes long. The first two bytes hold
+	// the group number, most significant byte first, followed by the
+	// zero terminated name
+	units := unsafe.Slice((*byte)(unsafe.Pointer(table)), int(count)*int(entrySize))
+	entries := make([]nameEntry, 0, int(count))
+	for i := 0; i < int(count); i++ {
+		entry := units[i*int(entrySize) : (i+1)*int(entrySize)]
+		name := entry[2:]
+		if j := bytes.IndexByte(name, 0); j >= 0 {
+			name = name[:j]
+		}
+		entries = append(entries, nameEntry{name: string(name), group: int(entry[0])<<8 | int(entry[1])})
+	}
+	return entries
+}
+
+// SubexpNames returns the names of the parenthesized subexpressions
+// in this Regexp. The name for the first sub-expression is names[1],
+// so that if m is a match slice, the name for m[i] is SubexpNames()[i].
+// Since the Regexp as a whole cannot be named, names[0] is always
+// the empty string.
+func (r *Regexp) SubexpNames() []string {
+	names := make([]string, r.NumSubexp()+1)
+	for _, e := range r.names {
+		names[e.group] = e.name
+	}
+	return names
+}
+
+// SubexpIndex returns the index of the first subexpression with the
+// given name, or -1 if there is no subexpression with that name.
+func (r *Regexp) SubexpIndex(name string) int {
+	if name == "" {
+		return -1
+	}
+
+	for _, e := range r.names {
+		if e.name == name {
+			return e.group
+		}
+	}
+	return -1
+}
+
+// SubexpIndices returns the indices of all subexpressions with the
+// given name, in ascending order. More than one subexpression may have
+// the same name if the pattern was compiled with DupNames, or uses
+// (?J). If there is no subexpression with that name, nil is returned.
+func (r *Regexp) SubexpIndices(name string) []int {
+	var indices []int
+	for _, e := range r.names {
+		if e.name == name {
+			indices = append(indices, e.group)
+		}
+	}
+	sort.Ints(indices)
+	return indices
+}
@@ -0,0 +1,134 @@
+package pcre2
+
+/*
+#define PCRE2_CODE_UNIT_WIDTH 8
+#include <pcre2.h>
+*/
+import "C"
+import (
+	"context"
+	"unicode/utf8"
+)
+
+// Partial matching modes. See pcre2partial(3) for the details
+const (
+	// PartialSoft prefers a full match over a partial one: a partial
+	// match is only reported if no full match can be found
+	PartialSoft PartialMode = C.PCRE2_PARTIAL_SOFT
+	// PartialHard reports a partial match as soon as one is found,
+	// even if a full match could be found later. The end of the
+	// subject is assumed not to be the end of the input, so \z, \b and
+	// $ always match partially there. This is what is needed when the
+	// input arrives in pieces.
+	PartialHard PartialMode = C.PCRE2_PARTIAL_HARD
+)
+
+// Results of a partial match attempt
+const (
+	// NoMatch means that no match can start anywhere in the subject,
+	// however much input is appended
+	NoMatch PartialStatus = iota
+	// FullMatch means that a complete match was found
+	FullMatch
+	// PartialMatch means that the end of the subject was reached
+	// while matching, and appending more input may complete the match
+	PartialMatch
+)
+
+// MatchPartial matches the Regexp against b, which may be followed by
+// more input that is not yet available. The result tells whether b
+// matches fully, partially, or not at all. Index is in the same format
+// as the result of FindIndex.
+//
+// Partial matching is also done by JIT compiled code, if the pattern
+// was JIT compiled with JITPartialSoft or JITPartialHard, depending on
+// the mode. Otherwise the interpreter is used.
+func (r *Regexp) MatchPartial(b []byte, mode PartialMode) (PartialResult, error) {
+	return r.partial(b, mode, 1)
+}
+
+// MatchPartialString is like MatchPartial, but matches against s
+func (r *Regexp) MatchPartialString(s string, mode PartialMode) (PartialResult, error) {
+	return r.partial(stringBytes(s), mode, 1)
+}
+
+// FindPartial is like MatchPartial, but Index is in the same format as
+// the result of FindSubmatchIndex. Groups are only reported for full
+// matches, as PCRE2 does not record them for partial matches.
+func (r *Regexp) FindPartial(b []byte, mode PartialMode) (PartialResult, error) {
+	return r.partial(b, mode, r.NumSubexp()+1)
+}
+
+// FindPartialString is like FindPartial, but matches against s
+func (r *Regexp) FindPartialString(s string, mode PartialMode) (PartialResult, error) {
+	return r.partial(stringBytes(s), mode, r.NumSubexp()+1)
+}
+
+func (r *Regexp) partial(subject []byte, mode PartialMode, howmany int) (PartialResult, error) {
+	if mode != PartialSoft && mode != PartialHard {
+		return PartialResult{Status: NoMatch, Keep: -1}, ErrInvalidPartialMode
+	}
+
+	rptr, err := r.acquire()
+	if err != nil {
+		return PartialResult{Status: NoMatch, Keep: -1}, err
+	}
+	defer r.release()
+
+	md := r.getMatchData()
+	defer r.putMatchData(md)
+
+	prepared, repl := r.prepareSubject(subject)
+	rc, err := r.matchSubjectContext(context.Background(), rptr, prepared, 0, int(mode), md.ptr, nil)
+	if err != nil {
+		return PartialResult{Status: NoMatch, Keep: -1}, err
+	}
+
+	switch {
+	case rc >= 0:
+		return PartialResult{
+			Status: FullMatch,
+			Index:  ovectorToIndex(pcre2GetOvectorPointer(md.ptr, howmany), howmany, repl),
+			Keep:   -1,
+		}, nil
+	case rc == C.PCRE2_ERROR_PARTIAL:
+		// Only the first pair is set for partial matches
+		index := ovectorToIndex(pcre2GetOvectorPointer(md.ptr, 1), 1, repl)
+		for len(index) < 2*howmany {
+			index = append(index, -1)
+		}
+		return PartialResult{
+			Status: PartialMatch,
+			Index:  index,
+			Keep:   lookbehindStart(rptr, subject, index[0]),
+		}, nil
+	}
+	return PartialResult{Status: NoMatch, Keep: -1}, nil
+}
+
+// lookbehindStart returns the offset that is as many characters before
+// start as the longest lookbehind in the pattern. Characters before the
+// start of a partial match may have been inspected by a lookbehind, so
+// they may be needed when the match is retried. As PCRE2 does not
+// report which characters were inspected, nested lookbehinds such as
+// (?<=(?<!b)a) may need more.
+func lookbehindStart(rptr *C.pcre2_code, subject []byte, start int) int {
+	return charsBefore(rptr, subject, start, int(infoUint32(rptr, C.PCRE2_INFO_MAXLOOKBEHIND)))
+}
+
+// charsBefore returns the offset that is n characters before start
+// in subject, or 0 if there are fewer characters than that
+func charsBefore(rptr *C.pcre2_code, subject []byte, start, n int) int {
+	if !hasOption(rptr, UTF) {
+		if n > start {
+			return 0
+		}
+		return start - n
+	}
+
+	for ; n > 0 && start > 0; n-- {
+		_, size := utf8.DecodeLastRune(subject[:start])
+		start -= size
+	}
+	return start
+}
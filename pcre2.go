@@ -2,19 +2,35 @@
 Package pcre2 is a wrapper around PCRE2 C library. This library aims to
 provide compatible API as that of regexp package from Go stdlib.
 
-Note that while PCRE2 provides support for 8, 16, and 32 bit inputs,
-this library assumes UTF-8 (32bit) input. Therefore if you use anything
-other than UTF-8, matches will not succeed.
+This library uses the 8 bit code unit width of PCRE2, and patterns and
+subjects are always treated as UTF-8, unless NeverUTF is given. Byte
+slices and strings are passed to PCRE2 without copying, so all offsets
+that are reported are byte offsets, just like in the regexp package.
+By default, matching against input that is not valid UTF-8 fails. See
+Regexp.SetInvalidUTF8Mode for other ways of handling such input.
 */
 package pcre2
 
 /*
-#define PCRE2_CODE_UNIT_WIDTH 32
-#cgo pkg-config: libpcre2-32
+#define PCRE2_CODE_UNIT_WIDTH 8
+#cgo pkg-config: libpcre2-8
 #include <stdio.h>
 #include <stdlib.h>
+#include <stdint.h>
 #include <pcre2.h>
 
+// Options that only exist in newer versions of PCRE2. Passing these to
+// an older library results in a compile error from PCRE2 itself
+#ifndef PCRE2_EXTENDED_MORE
+#define PCRE2_EXTENDED_MORE 0x01000000u
+#endif
+#ifndef PCRE2_LITERAL
+#define PCRE2_LITERAL 0x02000000u
+#endif
+#ifndef PCRE2_ERROR_DFA_UINVALID_UTF
+#define PCRE2_ERROR_DFA_UINVALID_UTF (-66)
+#endif
+
 #define MY_PCRE2_ERROR_MESSAGE_BUF_LEN 256
 static
 void *
@@ -24,83 +40,215 @@ MY_pcre2_get_error_message(int errnum) {
 	return buf;
 }
 
+extern int goCallout(pcre2_callout_block *, uintptr_t);
+
+static
+int
+MY_pcre2_callout(pcre2_callout_block *block, void *data) {
+	return goCallout(block, (uintptr_t) data);
+}
+
+static
+void
+MY_pcre2_set_callout(pcre2_match_context *mcontext, uintptr_t handle) {
+	pcre2_set_callout(mcontext, MY_pcre2_callout, (void *) handle);
+}
+
 */
 import "C"
 import (
+	"context"
 	"fmt"
-	"reflect"
+	"runtime"
+	"runtime/cgo"
+	"runtime/debug"
 	"unicode/utf8"
 	"unsafe"
 )
 
+// Compile options. See pcre2api(3) for the details of each option
+const (
+	AllowEmptyClass   Option = C.PCRE2_ALLOW_EMPTY_CLASS
+	AltBSUX           Option = C.PCRE2_ALT_BSUX
+	AltCircumflex     Option = C.PCRE2_ALT_CIRCUMFLEX
+	AltVerbNames      Option = C.PCRE2_ALT_VERBNAMES
+	Anchored          Option = C.PCRE2_ANCHORED
+	AutoCallout       Option = C.PCRE2_AUTO_CALLOUT
+	Caseless          Option = C.PCRE2_CASELESS
+	DollarEndOnly     Option = C.PCRE2_DOLLAR_ENDONLY
+	DotAll            Option = C.PCRE2_DOTALL
+	DupNames          Option = C.PCRE2_DUPNAMES
+	EndAnchored       Option = C.PCRE2_ENDANCHORED
+	Extended          Option = C.PCRE2_EXTENDED
+	ExtendedMore      Option = C.PCRE2_EXTENDED_MORE
+	FirstLine         Option = C.PCRE2_FIRSTLINE
+	Literal           Option = C.PCRE2_LITERAL
+	MatchUnsetBackref Option = C.PCRE2_MATCH_UNSET_BACKREF
+	Multiline         Option = C.PCRE2_MULTILINE
+	NeverBackslashC   Option = C.PCRE2_NEVER_BACKSLASH_C
+	NeverUCP          Option = C.PCRE2_NEVER_UCP
+	NeverUTF          Option = C.PCRE2_NEVER_UTF
+	NoAutoCapture     Option = C.PCRE2_NO_AUTO_CAPTURE
+	NoAutoPossess     Option = C.PCRE2_NO_AUTO_POSSESS
+	NoDotStarAnchor   Option = C.PCRE2_NO_DOTSTAR_ANCHOR
+	NoStartOptimize   Option = C.PCRE2_NO_START_OPTIMIZE
+	UCP               Option = C.PCRE2_UCP
+	Ungreedy          Option = C.PCRE2_UNGREEDY
+	UTF               Option = C.PCRE2_UTF
+)
+
 // Error returns the string representation of the error.
 func (e ErrCompile) Error() string {
 	return fmt.Sprintf("PCRE2 compilation failed at offset %d: %s", e.offset, e.message)
 }
 
-func strToRuneArray(s string) ([]rune, []int, error) {
-	rs := []rune{}
-	ls := []int{} // length of each rune
-	for len(s) > 0 {
-		r, n := utf8.DecodeRuneInString(s)
-		if r == utf8.RuneError {
-			return nil, nil, ErrInvalidUTF8String
+// Error returns the string representation of the error.
+func (e ErrJIT) Error() string {
+	return fmt.Sprintf("PCRE2 JIT compilation failed (%d): %s", e.code, e.message)
+}
+
+// Error returns the string representation of the error.
+func (e ErrSubstitute) Error() string {
+	return fmt.Sprintf("PCRE2 substitution failed at offset %d: %s", e.offset, e.message)
+}
+
+// Offset returns the byte offset in the replacement string where
+// the error was detected
+func (e ErrSubstitute) Offset() int {
+	return e.offset
+}
+
+// Error returns the string representation of the error.
+func (e MatchError) Error() string {
+	return fmt.Sprintf("PCRE2 match failed (%d): %s", e.code, e.message)
+}
+
+// Code returns the PCRE2 error code, such as PCRE2_ERROR_MATCHLIMIT
+func (e MatchError) Code() int {
+	return e.code
+}
+
+// Is reports whether target is the sentinel error that corresponds to
+// the PCRE2 error code, so that errors.Is(err, ErrMatchLimit) works.
+func (e MatchError) Is(target error) bool {
+	switch target {
+	case ErrMatchLimit:
+		return e.code == C.PCRE2_ERROR_MATCHLIMIT
+	case ErrDepthLimit:
+		return e.code == C.PCRE2_ERROR_DEPTHLIMIT
+	case ErrHeapLimit:
+		return e.code == C.PCRE2_ERROR_HEAPLIMIT
+	case ErrCalloutAbort:
+		return e.code == C.PCRE2_ERROR_CALLOUT
+	case ErrInvalidUTF8String:
+		return e.code <= C.PCRE2_ERROR_UTF8_ERR1 && e.code >= C.PCRE2_ERROR_UTF8_ERR21
+	case ErrDFAUnsupported:
+		switch e.code {
+		case C.PCRE2_ERROR_DFA_UITEM, C.PCRE2_ERROR_DFA_UCOND, C.PCRE2_ERROR_DFA_UFUNC, C.PCRE2_ERROR_DFA_UINVALID_UTF:
+			return true
 		}
-		s = s[n:]
-		rs = append(rs, r)
-		ls = append(ls, n)
 	}
-	return rs, ls, nil
+	return false
 }
 
-func bytesToRuneArray(b []byte) ([]rune, []int, error) {
-	rs := []rune{} // actual runes
-	ls := []int{}  // length of each rune
-	for len(b) > 0 {
-		r, n := utf8.DecodeRune(b)
-		if r == utf8.RuneError {
-			return nil, nil, ErrInvalidUTF8String
-		}
-		b = b[n:]
-		rs = append(rs, r)
-		ls = append(ls, n)
+func errorMessage(errnum C.int) string {
+	rawbytes := C.MY_pcre2_get_error_message(errnum)
+	defer C.free(rawbytes)
+
+	return C.GoString((*C.char)(rawbytes))
+}
+
+// emptySubject is passed to PCRE2 in place of empty subjects, so that
+// we always have a valid pointer
+var emptySubject = []byte{0}
+
+// subjectPtr returns a pointer to the first byte of b that can be
+// passed to PCRE2. PCRE2 never modifies the subject.
+func subjectPtr(b []byte) C.PCRE2_SPTR {
+	if len(b) == 0 {
+		return (C.PCRE2_SPTR)(unsafe.Pointer(&emptySubject[0]))
 	}
-	return rs, ls, nil
+	return (C.PCRE2_SPTR)(unsafe.Pointer(&b[0]))
+}
+
+// stringBytes returns the bytes of s without copying them. The
+// returned slice must never be modified.
+func stringBytes(s string) []byte {
+	return unsafe.Slice(unsafe.StringData(s), len(s))
 }
 
 // Compile takes the input string and creates a compiled Regexp object.
-// Regexp objects created by Compile must be released by calling Free
+// Regexp objects created by Compile should be released by calling Free
+// once they are no longer needed. Regexps that are garbage collected
+// without being freed are released by a finalizer, but as the garbage
+// collector does not know about the memory held by PCRE2, this may
+// happen much later than you expect.
 func Compile(pattern string) (*Regexp, error) {
-	patc, _, err := strToRuneArray(pattern)
+	return CompileWithOptions(pattern, 0)
+}
+
+// CompileWithOptions is like Compile, but allows you to specify
+// PCRE2 compile options, such as Caseless or Multiline. Multiple
+// options may be given, and they are OR'ed together.
+func CompileWithOptions(pattern string, options ...Option) (*Regexp, error) {
+	var flags Option
+	for _, o := range options {
+		flags |= o
+	}
+
+	if !utf8.ValidString(pattern) {
+		return nil, ErrInvalidUTF8String
+	}
+
+	// Patterns and subjects are always UTF-8, unless explicitly
+	// disabled with NeverUTF
+	if flags&NeverUTF == 0 {
+		flags |= UTF
+	}
+
+	re, err := compile(pattern, flags)
 	if err != nil {
 		return nil, err
 	}
+	return newRegexp(pattern, re), nil
+}
+
+// newRegexp wraps a compiled pattern in a Regexp, which takes over the
+// ownership of re
+func newRegexp(pattern string, re *C.pcre2_code) *Regexp {
+	ret := &Regexp{
+		pattern: pattern,
+		ptr:     unsafe.Pointer(re),
+	}
+	ret.refs.Store(1)
+	ret.subexps = captureCount(re)
+	ret.names = nameTable(re)
+	if leakDetector() != nil {
+		ret.stack = debug.Stack()
+	}
+	runtime.SetFinalizer(ret, (*Regexp).finalize)
+	return ret
+}
 
+func compile(pattern string, flags Option) (*C.pcre2_code, error) {
 	var errnum C.int
 	var erroff C.PCRE2_SIZE
 	re := C.pcre2_compile(
-		(C.PCRE2_SPTR)(unsafe.Pointer(&patc[0])),
-		C.size_t(len(patc)),
-		0,
+		subjectPtr(stringBytes(pattern)),
+		C.size_t(len(pattern)),
+		C.uint32_t(flags),
 		&errnum,
 		&erroff,
 		nil,
 	)
 	if re == nil {
-		rawbytes := C.MY_pcre2_get_error_message(errnum)
-		msg := C.GoBytes(rawbytes, 32/8*256)
-		defer C.free(unsafe.Pointer(rawbytes))
-
 		return nil, ErrCompile{
 			pattern: pattern,
 			offset:  int(erroff),
-			message: string(msg),
+			message: errorMessage(errnum),
 		}
 	}
-	return &Regexp{
-		pattern: pattern,
-		ptr:     uintptr(unsafe.Pointer(re)),
-	}, nil
+	return re, nil
 }
 
 // MustCompile is like Compile but panics if the expression cannot be
@@ -113,104 +261,268 @@ func MustCompile(pattern string) *Regexp {
 	return r
 }
 
-func (r *Regexp) validRegexpPtr() (*C.pcre2_code, error) {
+// acquire returns the compiled pattern, and keeps it from being freed
+// until release is called. The compiled pattern is reference counted,
+// so Free never blocks acquire, and the last release after Free frees
+// it. Calls to acquire must not be nested, as a pending lock would
+// block the inner call forever.
+func (r *Regexp) acquire() (*C.pcre2_code, error) {
+	if r == nil {
+		return nil, ErrInvalidRegexp
+	}
+
+	r.mu.RLock()
+	if !r.ref() {
+		r.mu.RUnlock()
+		return nil, ErrInvalidRegexp
+	}
+	return (*C.pcre2_code)(r.ptr), nil
+}
+
+func (r *Regexp) release() {
+	r.unref()
+	r.mu.RUnlock()
+}
+
+// lock is like acquire, but also waits for in-flight matches to
+// finish, and keeps new ones from starting until unlock is called.
+// It is used by methods that modify the Regexp.
+func (r *Regexp) lock() (*C.pcre2_code, error) {
 	if r == nil {
 		return nil, ErrInvalidRegexp
 	}
 
-	if rptr := r.ptr; rptr != 0 {
-		return (*C.pcre2_code)(unsafe.Pointer(rptr)), nil
+	r.mu.Lock()
+	if !r.ref() {
+		r.mu.Unlock()
+		return nil, ErrInvalidRegexp
+	}
+	return (*C.pcre2_code)(r.ptr), nil
+}
+
+func (r *Regexp) unlock() {
+	r.unref()
+	r.mu.Unlock()
+}
+
+// ref takes a reference to the compiled pattern. It fails once Free
+// has been called.
+func (r *Regexp) ref() bool {
+	for {
+		// Once the count has dropped to zero, the pattern is freed
+		n := r.refs.Load()
+		if n == 0 || r.freed.Load() {
+			return false
+		}
+		if r.refs.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// unref drops a reference to the compiled pattern, and frees it when
+// that was the last one
+func (r *Regexp) unref() {
+	if r.refs.Add(-1) == 0 {
+		r.free()
 	}
-	return nil, ErrInvalidRegexp
 }
 
-// Free releases the underlying C resources
+// Free releases the underlying C resources. It is safe to call Free
+// more than once: calls after the first do nothing.
+//
+// Free may be called while other goroutines are using the Regexp, and
+// never waits for them. Matches that are in progress finish normally,
+// and the resources are released when the last of them returns.
+// Matches that are started afterwards fail with ErrInvalidRegexp.
 func (r *Regexp) Free() error {
-	rptr, err := r.validRegexpPtr()
-	if err != nil {
-		return err
+	if r == nil {
+		return ErrInvalidRegexp
+	}
+	if r.freed.Swap(true) {
+		return nil
 	}
-	C.pcre2_code_free(rptr)
-	r.ptr = 0
+
+	runtime.SetFinalizer(r, nil)
+	// Drop the reference that the Regexp was created with
+	r.unref()
 	return nil
 }
 
+// Close is the same as Free. It allows a Regexp to be used as an
+// io.Closer
+func (r *Regexp) Close() error {
+	return r.Free()
+}
+
+func (r *Regexp) free() {
+	C.pcre2_code_free((*C.pcre2_code)(r.ptr))
+	r.ptr = nil
+	r.freeAutoCallout()
+	if r.jitStacks != nil {
+		r.jitStacks.free()
+		r.jitStacks = nil
+	}
+}
+
 // String returns the source text used to compile the regular expression.
-func (r Regexp) String() string {
+func (r *Regexp) String() string {
 	return r.pattern
 }
 
 func (r *Regexp) Match(b []byte) bool {
-	rs, _, err := bytesToRuneArray(b)
-	if err != nil {
-		return false
-	}
-	return r.matchRuneArray(rs, 0, 0, nil) >= 0
+	ok, _ := r.matchWithLimits(context.Background(), b, nil)
+	return ok
 }
 
 func (r *Regexp) MatchString(s string) bool {
-	rs, _, err := strToRuneArray(s)
-	if err != nil {
-		return false
-	}
-	return r.matchRuneArray(rs, 0, 0, nil) >= 0
+	ok, _ := r.matchWithLimits(context.Background(), stringBytes(s), nil)
+	return ok
 }
 
-func (r *Regexp) matchRuneArray(rs []rune, offset int, options int, matchData *C.pcre2_match_data) int {
-	rptr, err := r.validRegexpPtr()
-	if err != nil {
-		return -1
-	}
-
+// matchSubject runs a single match of rptr against subject. The caller
+// must have acquired rptr. Callouts abort the match once ctx is
+// cancelled. The error is only set if the match could not be tried.
+func (r *Regexp) matchSubject(ctx context.Context, rptr *C.pcre2_code, subject []byte, offset int, options int, matchData *C.pcre2_match_data, limits *Limits) (int, error) {
 	if matchData == nil {
-		matchData = C.pcre2_match_data_create_from_pattern(rptr, nil)
-		defer C.pcre2_match_data_free(matchData)
+		md := r.getMatchData()
+		defer r.putMatchData(md)
+		matchData = md.ptr
+	}
+	if r.longest {
+		return r.matchLongest(ctx, rptr, subject, offset, options, matchData, limits)
+	}
+
+	callout := r.newCalloutState(ctx)
+	matchContext, release := r.newMatchContext(r.effectiveLimits(limits), callout)
+	defer release()
+
+	// pcre2_jit_match skips the sanity checks that pcre2_match does,
+	// so only use it for the mode that the pattern was compiled for,
+	// with the options that JIT supports, and for subjects that are
+	// known to be valid UTF-8. Otherwise pcre2_match reports the
+	// problem for us, or falls back to the interpreter
+	jitMode := JITComplete
+	switch {
+	case options&C.PCRE2_PARTIAL_HARD != 0:
+		jitMode = JITPartialHard
+	case options&C.PCRE2_PARTIAL_SOFT != 0:
+		jitMode = JITPartialSoft
+	}
+
+	var rc C.int
+	if r.jit&jitMode != 0 && options&^jitMatchOptions == 0 &&
+		(options&C.PCRE2_NO_UTF_CHECK != 0 || utf8.Valid(subject)) {
+		rc = C.pcre2_jit_match(
+			rptr,
+			subjectPtr(subject),
+			C.size_t(len(subject)),
+			(C.PCRE2_SIZE)(offset),
+			(C.uint32_t)(options),
+			matchData,
+			matchContext,
+		)
+	} else {
+		rc = C.pcre2_match(
+			rptr,
+			subjectPtr(subject),
+			C.size_t(len(subject)),
+			(C.PCRE2_SIZE)(offset),
+			(C.uint32_t)(options),
+			matchData,
+			matchContext,
+		)
+	}
+
+	callout.rethrow()
+	return int(rc), nil
+}
+
+// newMatchContext creates a match context for a single call to
+// pcre2_match, if the Regexp requires one. The returned function
+// must be called once the match is done.
+func (r *Regexp) newMatchContext(limits Limits, callout *calloutState) (*C.pcre2_match_context, func()) {
+	pool := r.jitStacks
+	if pool == nil && callout == nil && limits == (Limits{}) {
+		return nil, func() {}
+	}
+
+	matchContext := C.pcre2_match_context_create(nil)
+	limits.apply(matchContext)
+
+	var stack *C.pcre2_jit_stack
+	if pool != nil {
+		stack = pool.get()
+		C.pcre2_jit_stack_assign(matchContext, nil, unsafe.Pointer(stack))
+	}
+
+	// Go pointers may not be stored in C memory, so PCRE2 is given a
+	// handle to the callout state instead
+	var handle cgo.Handle
+	if callout != nil {
+		handle = cgo.NewHandle(callout)
+		C.MY_pcre2_set_callout(matchContext, C.uintptr_t(handle))
+	}
+
+	return matchContext, func() {
+		C.pcre2_match_context_free(matchContext)
+		if stack != nil {
+			pool.put(stack)
+		}
+		if callout != nil {
+			handle.Delete()
+		}
 	}
-
-	rc := C.pcre2_match(
-		rptr,
-		(C.PCRE2_SPTR)(unsafe.Pointer(&rs[0])),
-		C.size_t(len(rs)),
-		(C.PCRE2_SIZE)(offset),
-		(C.uint32_t)(options),
-		matchData,
-		nil,
-	)
-
-	return int(rc)
 }
 
+// jitMatchOptions are the match options that pcre2_jit_match supports.
+// The partial options require the matching JIT mode
+const jitMatchOptions = C.PCRE2_NOTBOL | C.PCRE2_NOTEOL | C.PCRE2_NOTEMPTY |
+	C.PCRE2_NOTEMPTY_ATSTART | C.PCRE2_NO_UTF_CHECK |
+	C.PCRE2_PARTIAL_SOFT | C.PCRE2_PARTIAL_HARD
+
 func pcre2GetOvectorPointer(matchData *C.pcre2_match_data, howmany int) []C.size_t {
-	ovector := C.pcre2_get_ovector_pointer(matchData)
-	// Note that by doing this SliceHeader maigc, we allow Go
-	// slice syntax but Go doesn't own the underlying pointer.
-	// We need to free it. In this case, it means the caller
-	// must remember to free matchData
-	hdr := reflect.SliceHeader{
-		Data: uintptr(unsafe.Pointer(ovector)),
-		Len:  howmany * 2,
-		Cap:  howmany * 2,
+	// Note that the returned slice points to memory owned by
+	// matchData, so the caller must not use it after matchData
+	// has been freed
+	return unsafe.Slice(C.pcre2_get_ovector_pointer(matchData), howmany*2)
+}
+
+// ovectorToIndex converts the first howmany pairs of offsets in
+// ovector to offsets in the original subject. Unset groups are
+// reported as -1, like the regexp package does.
+func ovectorToIndex(ovector []C.size_t, howmany int, repl utf8Replacements) []int {
+	out := make([]int, 0, 2*howmany)
+	for i := 0; i < 2*howmany; i++ {
+		if ovector[i] == C.PCRE2_UNSET {
+			out = append(out, -1)
+			continue
+		}
+		out = append(out, repl.originalOffset(int(ovector[i])))
 	}
-	return *(*[]C.size_t)(unsafe.Pointer(&hdr))
+	return out
 }
 
-func (r *Regexp) HasOption(opt int) bool {
-	rptr, err := r.validRegexpPtr()
+// HasOption returns true if the compiled pattern has the given option
+// set. This includes options that were set from within the pattern,
+// such as (?i)
+func (r *Regexp) HasOption(opt Option) bool {
+	rptr, err := r.acquire()
 	if err != nil {
 		return false
 	}
+	defer r.release()
+
+	return hasOption(rptr, opt)
+}
 
+func hasOption(rptr *C.pcre2_code, opt Option) bool {
 	var i C.uint32_t
 	C.pcre2_pattern_info(rptr, C.PCRE2_INFO_ALLOPTIONS, unsafe.Pointer(&i))
 	return (uint32(i) & uint32(opt)) != 0
 }
 
-func (r *Regexp) isCRLFValid() bool {
-	rptr, err := r.validRegexpPtr()
-	if err != nil {
-		return false
-	}
-
+func isCRLFValid(rptr *C.pcre2_code) bool {
 	var i C.uint32_t
 	C.pcre2_pattern_info(rptr, C.PCRE2_INFO_NEWLINE, unsafe.Pointer(&i))
 	switch i {
@@ -222,16 +534,8 @@ func (r *Regexp) isCRLFValid() bool {
 }
 
 func (r *Regexp) FindIndex(b []byte) []int {
-	rs, ls, err := bytesToRuneArray(b)
-	if err != nil {
-		return nil
-	}
-
-	is := r.findAllIndex(rs, ls, 1)
-	if len(is) != 1 {
-		return nil
-	}
-	return is[0]
+	is, _ := r.findIndex(b)
+	return is
 }
 
 func (r *Regexp) Find(b []byte) []byte {
@@ -243,16 +547,16 @@ func (r *Regexp) Find(b []byte) []byte {
 }
 
 func (r *Regexp) FindStringIndex(s string) []int {
-	rs, ls, err := strToRuneArray(s)
-	if err != nil {
-		return nil
-	}
+	is, _ := r.findIndex(stringBytes(s))
+	return is
+}
 
-	is := r.findAllIndex(rs, ls, 1)
-	if len(is) != 1 {
-		return nil
+func (r *Regexp) findIndex(subject []byte) ([]int, error) {
+	all, err := r.findAllIndex(context.Background(), subject, 1, nil)
+	if len(all) != 1 {
+		return nil, err
 	}
-	return is[0]
+	return all[0], nil
 }
 
 func (r *Regexp) FindSubmatch(b []byte) [][]byte {
@@ -263,61 +567,31 @@ func (r *Regexp) FindSubmatch(b []byte) [][]byte {
 
 	ret := make([][]byte, 0, len(matches)/2)
 	for i := 0; i < len(matches)/2; i++ {
+		if matches[2*i] < 0 {
+			ret = append(ret, nil)
+			continue
+		}
 		ret = append(ret, b[matches[2*i]:matches[2*i+1]])
 	}
 	return ret
 }
 
 func (r *Regexp) FindSubmatchIndex(b []byte) []int {
-	rs, ls, err := bytesToRuneArray(b)
-	if err != nil {
-		return nil
-	}
-	return r.findSubmatchIndex(rs, ls)
+	is, _ := r.findSubmatchIndex(b)
+	return is
 }
 
 func (r *Regexp) FindStringSubmatchIndex(s string) []int {
-	rs, ls, err := strToRuneArray(s)
-	if err != nil {
-		return nil
-	}
-	return r.findSubmatchIndex(rs, ls)
+	is, _ := r.findSubmatchIndex(stringBytes(s))
+	return is
 }
 
-func (r *Regexp) findSubmatchIndex(rs []rune, ls []int) []int {
-	rptr, err := r.validRegexpPtr()
-	if err != nil {
-		return nil
-	}
-
-	matchData := C.pcre2_match_data_create_from_pattern(rptr, nil)
-	defer C.pcre2_match_data_free(matchData)
-
-	out := []int(nil)
-	options := 0
-
-	count := r.matchRuneArray(rs, 0, options, matchData)
-	if count <= 0 {
-		return nil
-	}
-
-	ovector := pcre2GetOvectorPointer(matchData, count)
-	for i := 0; i < count; i++ {
-		ovec0 := int(ovector[2*i])
-		b1 := 0
-		for x := 0; x < ovec0; x++ {
-			b1 += ls[x]
-		}
-
-		ovec1 := int(ovector[2*i+1])
-		b2 := b1
-		for x := ovec0; x < ovec1; x++ {
-			b2 += ls[x]
-		}
-		out = append(out, []int{b1, b2}...)
+func (r *Regexp) findSubmatchIndex(subject []byte) ([]int, error) {
+	all, err := r.findAllSubmatchIndex(context.Background(), subject, 1, nil)
+	if len(all) != 1 {
+		return nil, err
 	}
-
-	return out
+	return all[0], nil
 }
 
 func (r *Regexp) FindStringSubmatch(s string) []string {
@@ -326,8 +600,12 @@ func (r *Regexp) FindStringSubmatch(s string) []string {
 		return nil
 	}
 
-	ret := make([]string, 0, len(matches))
+	ret := make([]string, 0, len(matches)/2)
 	for i := 0; i < len(matches)/2; i++ {
+		if matches[2*i] < 0 {
+			ret = append(ret, "")
+			continue
+		}
 		ret = append(ret, s[matches[2*i]:matches[2*i+1]])
 	}
 	return ret
@@ -342,12 +620,9 @@ func (r *Regexp) FindString(s string) string {
 }
 
 func (r *Regexp) FindAll(b []byte, n int) [][]byte {
-	rs, ls, err := bytesToRuneArray(b)
-	if err != nil {
-		return nil
-	}
 	ret := [][]byte(nil)
-	for _, is := range r.findAllIndex(rs, ls, n) {
+	all, _ := r.findAllIndex(context.Background(), b, n, nil)
+	for _, is := range all {
 		ret = append(ret, b[is[0]:is[1]])
 	}
 	return ret
@@ -358,12 +633,9 @@ func (r *Regexp) FindAllString(s string, n int) []string {
 		return nil
 	}
 
-	rs, ls, err := strToRuneArray(s)
-	if err != nil {
-		return nil
-	}
 	ret := []string{}
-	for _, is := range r.findAllIndex(rs, ls, n) {
+	all, _ := r.findAllIndex(context.Background(), stringBytes(s), n, nil)
+	for _, is := range all {
 		ret = append(ret, s[is[0]:is[1]])
 		if n > 0 && len(ret) >= n {
 			break
@@ -372,133 +644,129 @@ func (r *Regexp) FindAllString(s string, n int) []string {
 	return ret
 }
 
-func (r *Regexp) findAllIndex(rs []rune, ls []int, n int) [][]int {
+// findAll calls fn for each of the first n successive,
+// non-overlapping matches of the Regexp in subject. If n < 0, all
+// matches are reported.
+//
+// The whole subject is always passed to PCRE2, along with the offset
+// at which to start searching, so that lookbehinds, \b, ^ and \G see
+// the text before the current position. Offsets in the match data
+// are therefore relative to the start of the subject, as prepared by
+// prepareSubject. fn receives the replacements that were made by
+// prepareSubject, which should be passed to ovectorToIndex.
+//
+// Empty matches are handled as in the regexp package: after an empty
+// match, the search moves on by one character, and an empty match
+// immediately after the previous match is not reported.
+func (r *Regexp) findAll(ctx context.Context, subject []byte, n int, limits *Limits, fn func(*C.pcre2_match_data, utf8Replacements)) error {
 	if n == 0 {
 		return nil
 	}
 
-	rptr, err := r.validRegexpPtr()
+	rptr, err := r.acquire()
 	if err != nil {
-		return nil
+		return err
 	}
+	defer r.release()
 
-	matchData := C.pcre2_match_data_create_from_pattern(rptr, nil)
-	defer C.pcre2_match_data_free(matchData)
+	md := r.getMatchData()
+	defer r.putMatchData(md)
+	matchData := md.ptr
 
-	out := [][]int(nil)
-	offset := 0
+	subject, repl := r.prepareSubject(subject)
+	found := 0
+	start := 0
+	prevEnd := -1
 	options := 0
-	for len(rs) > 0 {
-		count := r.matchRuneArray(rs, 0, options, matchData)
+	for start <= len(subject) {
+		count, err := r.matchSubjectContext(ctx, rptr, subject, start, options, matchData, limits)
+		if err != nil {
+			return err
+		}
 		if count <= 0 {
 			break
 		}
 
-		ovector := pcre2GetOvectorPointer(matchData, count)
-		ovec0 := int(ovector[0])
-		b1 := 0
-		for x := 0; x < ovec0; x++ {
-			b1 += ls[x]
-		}
-		b2 := b1
-		for x := ovec0; x < int(ovector[1]); x++ {
-			b2 += ls[x]
-		}
-		out = append(out, []int{offset + b1, offset + b2})
-		units := int(ovector[1])
-		for x := 0; x < units; x++ {
-			offset += ls[x]
+		// The subject has already been checked to be valid UTF-8,
+		// so there is no need to check it again. Subjects that may
+		// be invalid are never checked in InvalidUTF8Skip mode
+		options = 0
+		if r.invalidUTF8 != InvalidUTF8Skip {
+			options = C.PCRE2_NO_UTF_CHECK
 		}
 
-		rs = rs[units:]
-		ls = ls[units:]
+		ovector := pcre2GetOvectorPointer(matchData, 1)
+		matchStart := int(ovector[0])
+		matchEnd := int(ovector[1])
+		if matchStart != matchEnd || matchStart != prevEnd {
+			fn(matchData, repl)
+			found++
+			if n > 0 && found >= n {
+				break
+			}
+		}
 
-		if n > 0 && len(out) >= n {
-			break
+		prevEnd = matchEnd
+		start = matchEnd
+		if matchStart == matchEnd {
+			if matchEnd == len(subject) {
+				break
+			}
+			start += charLen(rptr, subject[start:])
 		}
 	}
 
-	return out
-}
-
-func (r *Regexp) FindAllIndex(b []byte, n int) [][]int {
-	rs, ls, err := bytesToRuneArray(b)
-	if err != nil {
-		return nil
-	}
-	return r.findAllIndex(rs, ls, n)
+	return nil
 }
 
-func (r *Regexp) FindAllStringIndex(s string, n int) [][]int {
-	rs, ls, err := strToRuneArray(s)
-	if err != nil {
-		return nil
+// charLen returns the length in bytes of the character at the start of
+// b. CRLF is treated as a single character if it is a valid newline
+// sequence for the pattern.
+func charLen(rptr *C.pcre2_code, b []byte) int {
+	if len(b) >= 2 && b[0] == '\r' && b[1] == '\n' && isCRLFValid(rptr) {
+		return 2
 	}
-	return r.findAllIndex(rs, ls, n)
-}
-
-func (r *Regexp) findAllSubmatchIndex(rs []rune, ls []int, n int) [][]int {
-	if n == 0 {
-		return nil
+	if !hasOption(rptr, UTF) {
+		return 1
 	}
-
-	rptr, err := r.validRegexpPtr()
-	if err != nil {
-		return nil
+	if _, size := utf8.DecodeRune(b); size > 0 {
+		return size
 	}
+	return 1
+}
 
-	matchData := C.pcre2_match_data_create_from_pattern(rptr, nil)
-	defer C.pcre2_match_data_free(matchData)
-
+func (r *Regexp) findAllIndex(ctx context.Context, subject []byte, n int, limits *Limits) ([][]int, error) {
 	out := [][]int(nil)
-	offset := 0
-	options := 0
-	for len(rs) > 0 {
-		count := r.matchRuneArray(rs, 0, options, matchData)
-		if count <= 0 {
-			break
-		}
-
-		ovector := pcre2GetOvectorPointer(matchData, count)
-		curmatch := make([]int, 0, count)
-		for i := 0; i < count; i++ {
-			ovec2i := int(ovector[2*i])
-
-			b1 := 0
-			for x := 0; x < ovec2i; x++ {
-				b1 += ls[x]
-			}
-			b2 := b1
-			for x := ovec2i; x < int(ovector[2*i+1]); x++ {
-				b2 += ls[x]
-			}
-			curmatch = append(curmatch, offset+b1, offset+b2)
-		}
-		out = append(out, curmatch)
-
-		units := int(ovector[1])
-		for x := 0; x < units; x++ {
-			offset += ls[x]
-		}
+	err := r.findAll(ctx, subject, n, limits, func(matchData *C.pcre2_match_data, repl utf8Replacements) {
+		ovector := pcre2GetOvectorPointer(matchData, 1)
+		out = append(out, ovectorToIndex(ovector, 1, repl))
+	})
+	return out, err
+}
 
-		rs = rs[units:]
-		ls = ls[units:]
+func (r *Regexp) FindAllIndex(b []byte, n int) [][]int {
+	all, _ := r.findAllIndex(context.Background(), b, n, nil)
+	return all
+}
 
-		if n > 0 && len(out) >= n {
-			break
-		}
-	}
+func (r *Regexp) FindAllStringIndex(s string, n int) [][]int {
+	all, _ := r.findAllIndex(context.Background(), stringBytes(s), n, nil)
+	return all
+}
 
-	return out
+func (r *Regexp) findAllSubmatchIndex(ctx context.Context, subject []byte, n int, limits *Limits) ([][]int, error) {
+	// Report every group, even those at the end that did not match
+	howmany := r.NumSubexp() + 1
+	out := [][]int(nil)
+	err := r.findAll(ctx, subject, n, limits, func(matchData *C.pcre2_match_data, repl utf8Replacements) {
+		ovector := pcre2GetOvectorPointer(matchData, howmany)
+		out = append(out, ovectorToIndex(ovector, howmany, repl))
+	})
+	return out, err
 }
 
 func (r *Regexp) FindAllSubmatch(b []byte, n int) [][][]byte {
-	rs, ls, err := bytesToRuneArray(b)
-	if err != nil {
-		return nil
-	}
-
-	all := r.findAllSubmatchIndex(rs, ls, n)
+	all, _ := r.findAllSubmatchIndex(context.Background(), b, n, nil)
 	if all == nil {
 		return nil
 	}
@@ -508,6 +776,10 @@ func (r *Regexp) FindAllSubmatch(b []byte, n int) [][][]byte {
 		l := len(is) / 2
 		cur := make([][]byte, 0, l)
 		for i := 0; i < l; i++ {
+			if is[2*i] < 0 {
+				cur = append(cur, nil)
+				continue
+			}
 			cur = append(cur, b[is[2*i]:is[2*i+1]])
 		}
 
@@ -520,12 +792,7 @@ func (r *Regexp) FindAllSubmatch(b []byte, n int) [][][]byte {
 }
 
 func (r *Regexp) FindAllStringSubmatch(s string, n int) [][]string {
-	rs, ls, err := strToRuneArray(s)
-	if err != nil {
-		return nil
-	}
-
-	all := r.findAllSubmatchIndex(rs, ls, n)
+	all, _ := r.findAllSubmatchIndex(context.Background(), stringBytes(s), n, nil)
 	if all == nil {
 		return nil
 	}
@@ -535,6 +802,10 @@ func (r *Regexp) FindAllStringSubmatch(s string, n int) [][]string {
 		l := len(is) / 2
 		cur := make([]string, 0, l)
 		for i := 0; i < l; i++ {
+			if is[2*i] < 0 {
+				cur = append(cur, "")
+				continue
+			}
 			cur = append(cur, s[is[2*i]:is[2*i+1]])
 		}
 		ret = append(ret, cur)
@@ -546,17 +817,11 @@ func (r *Regexp) FindAllStringSubmatch(s string, n int) [][]string {
 }
 
 func (r *Regexp) FindAllSubmatchIndex(b []byte, n int) [][]int {
-	rs, ls, err := bytesToRuneArray(b)
-	if err != nil {
-		return nil
-	}
-	return r.findAllSubmatchIndex(rs, ls, n)
+	all, _ := r.findAllSubmatchIndex(context.Background(), b, n, nil)
+	return all
 }
 
 func (r *Regexp) FindAllStringSubmatchIndex(s string, n int) [][]int {
-	rs, ls, err := strToRuneArray(s)
-	if err != nil {
-		return nil
-	}
-	return r.findAllSubmatchIndex(rs, ls, n)
+	all, _ := r.findAllSubmatchIndex(context.Background(), stringBytes(s), n, nil)
+	return all
 }
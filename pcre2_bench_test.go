@@ -1,23 +1,53 @@
 package pcre2_test
 
 import (
+	"io"
 	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/lestrrat/go-pcre2"
 )
 
 type regexper interface {
-	Match([]byte) bool
-	MatchString(string) bool
+	Find([]byte) []byte
+	FindAll([]byte, int) [][]byte
 	FindAllIndex([]byte, int) [][]int
-	FindAllSubmatchIndex([]byte, int) [][]int
+	FindAllString(string, int) []string
 	FindAllStringIndex(string, int) [][]int
+	FindAllStringSubmatch(string, int) [][]string
 	FindAllStringSubmatchIndex(string, int) [][]int
-	FindSubmatchIndex([]byte) []int
+	FindAllSubmatch([]byte, int) [][][]byte
+	FindAllSubmatchIndex([]byte, int) [][]int
+	FindIndex([]byte) []int
+	FindReaderIndex(io.RuneReader) []int
+	FindReaderSubmatchIndex(io.RuneReader) []int
+	FindString(string) string
+	FindStringIndex(string) []int
+	FindStringSubmatch(string) []string
 	FindStringSubmatchIndex(string) []int
+	FindSubmatch([]byte) [][]byte
+	FindSubmatchIndex([]byte) []int
+	Match([]byte) bool
+	MatchReader(io.RuneReader) bool
+	MatchString(string) bool
+	NumSubexp() int
+	ReplaceAll([]byte, []byte) []byte
+	ReplaceAllFunc([]byte, func([]byte) []byte) []byte
+	ReplaceAllLiteral([]byte, []byte) []byte
+	ReplaceAllLiteralString(string, string) string
+	ReplaceAllString(string, string) string
+	ReplaceAllStringFunc(string, func(string) string) string
+	String() string
+	SubexpIndex(string) int
+	SubexpNames() []string
 }
 
+var (
+	_ regexper = (*regexp.Regexp)(nil)
+	_ regexper = (*pcre2.Regexp)(nil)
+)
+
 func benchMatch(b *testing.B, re regexper, dos bool) {
 	patterns := []string{`Hello World!`, `Hello Friend!`, `Hello 友達!`}
 	for _, pat := range patterns {
@@ -103,6 +133,23 @@ func benchFindAllSubmatchIndex(b *testing.B, re regexper, dos bool) {
 	}
 }
 
+func benchFindReaderIndex(b *testing.B, re regexper, dos bool) {
+	patterns := []string{`Alice:35 Bob:42 Charlie:21`, `桃:三年 栗:三年 柿:八年`, `vini:came vidi:saw vici:won`}
+	for _, pat := range patterns {
+		var matches []int
+		if dos {
+			matches = re.FindReaderSubmatchIndex(strings.NewReader(pat))
+		} else {
+			matches = re.FindReaderIndex(strings.NewReader(pat))
+		}
+
+		if matches == nil {
+			b.Errorf("Expected to match '%s' against '%#v'", pat, re)
+			return
+		}
+	}
+}
+
 func makeBenchFunc(b *testing.B, which bool, dos bool, pattern string, f func(*testing.B, regexper, bool)) func() {
 	// Forcing a function call so that we have chance to
 	// run garbage collection for each iteration
@@ -252,3 +299,68 @@ func BenchmarkPCRE2FindAllStringSubmatchIndex(b *testing.B) {
 		benchf()
 	}
 }
+
+// FindReaderIndex, FindReaderSubmatchIndex
+const FindReaderIndexRegex = `(\S+):(\S+)`
+
+func BenchmarkGoFindReaderIndex(b *testing.B) {
+	benchf := makeBenchFunc(b, UseGoRegexp, UseBytes, FindReaderIndexRegex, benchFindReaderIndex)
+	for i := 0; i < b.N; i++ {
+		benchf()
+	}
+}
+
+func BenchmarkPCRE2FindReaderIndex(b *testing.B) {
+	benchf := makeBenchFunc(b, UsePCRE2Regexp, UseBytes, FindReaderIndexRegex, benchFindReaderIndex)
+	for i := 0; i < b.N; i++ {
+		benchf()
+	}
+}
+
+func BenchmarkGoFindReaderSubmatchIndex(b *testing.B) {
+	benchf := makeBenchFunc(b, UseGoRegexp, UseString, FindReaderIndexRegex, benchFindReaderIndex)
+	for i := 0; i < b.N; i++ {
+		benchf()
+	}
+}
+
+func BenchmarkPCRE2FindReaderSubmatchIndex(b *testing.B) {
+	benchf := makeBenchFunc(b, UsePCRE2Regexp, UseString, FindReaderIndexRegex, benchFindReaderIndex)
+	for i := 0; i < b.N; i++ {
+		benchf()
+	}
+}
+
+// Match against a Regexp that is compiled once, using ASCII subjects
+// that are converted outside of the loop, to measure the cost of the
+// match itself
+var compiledMatchSubjects = [][]byte{[]byte(`Hello World!`), []byte(`Goodbye World!`)}
+
+func benchCompiledMatch(b *testing.B, match func([]byte) bool) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if !match(compiledMatchSubjects[0]) || match(compiledMatchSubjects[1]) {
+			b.Errorf("unexpected match result")
+			return
+		}
+	}
+}
+
+func BenchmarkGoCompiledMatch(b *testing.B) {
+	re := regexp.MustCompile(RegexpMatchRegex)
+	benchCompiledMatch(b, re.Match)
+}
+
+func BenchmarkPCRE2CompiledMatch(b *testing.B) {
+	re := pcre2.MustCompile(RegexpMatchRegex)
+	defer re.Free()
+	benchCompiledMatch(b, re.Match)
+}
+
+func BenchmarkPCRE2MatcherMatch(b *testing.B) {
+	re := pcre2.MustCompile(RegexpMatchRegex)
+	defer re.Free()
+	m := re.NewMatcher()
+	defer m.Free()
+	benchCompiledMatch(b, m.Match)
+}
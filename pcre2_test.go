@@ -1,8 +1,22 @@
 package pcre2_test
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
 	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
+	"unicode/utf8"
 
 	"github.com/lestrrat/go-pcre2"
 	"github.com/stretchr/testify/assert"
@@ -395,3 +409,2052 @@ func TestFindSubmatchIndex(t *testing.T) {
 	}
 }
 
+func TestCompileWithOptions(t *testing.T) {
+	re, err := pcre2.CompileWithOptions(`^hello (.+)!$`, pcre2.Caseless, pcre2.Multiline)
+	if !assert.NoError(t, err, "CompileWithOptions works") {
+		return
+	}
+	defer re.Free()
+
+	if !assert.True(t, re.HasOption(pcre2.Caseless), "HasOption(Caseless) is true") {
+		return
+	}
+	if !assert.True(t, re.HasOption(pcre2.Multiline), "HasOption(Multiline) is true") {
+		return
+	}
+	if !assert.False(t, re.HasOption(pcre2.DotAll), "HasOption(DotAll) is false") {
+		return
+	}
+
+	if !assert.True(t, re.MatchString("HELLO World!"), "Caseless match succeeds") {
+		return
+	}
+	if !assert.True(t, re.MatchString("first line\nHello World!\nlast line"), "Multiline match succeeds") {
+		return
+	}
+
+	re2, err := pcre2.CompileWithOptions(`hello world # comment`, pcre2.Extended|pcre2.Caseless)
+	if !assert.NoError(t, err, "CompileWithOptions works") {
+		return
+	}
+	defer re2.Free()
+
+	if !assert.True(t, re2.MatchString("HelloWorld"), "Extended match succeeds") {
+		return
+	}
+}
+
+func TestJIT(t *testing.T) {
+	re, err := pcre2.Compile(`^Hello (.+)!$`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	defer re.Free()
+
+	if !pcre2.JITSupported() {
+		assert.Equal(t, pcre2.ErrJITUnsupported, re.JIT(), "JIT fails without JIT support")
+		assert.False(t, re.JITCompiled(pcre2.JITComplete), "JITCompiled is false")
+		return
+	}
+
+	if !assert.NoError(t, re.JIT(), "JIT works") {
+		return
+	}
+	if !assert.True(t, re.JITCompiled(pcre2.JITComplete), "JITCompiled(JITComplete) is true") {
+		return
+	}
+	if !assert.False(t, re.JITCompiled(pcre2.JITPartialHard), "JITCompiled(JITPartialHard) is false") {
+		return
+	}
+
+	if !assert.Equal(t, pcre2.ErrInvalidJITStackSize, re.SetJITStackSize(64*1024, 32*1024), "SetJITStackSize fails for max < start") {
+		return
+	}
+	if !assert.NoError(t, re.SetJITStackSize(32*1024, 512*1024), "SetJITStackSize works") {
+		return
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan string, 100)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if !re.MatchString(`Hello 友達!`) {
+					errs <- "expected match"
+				}
+				if re.MatchString(`Goodbye 友達!`) {
+					errs <- "expected no match"
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for msg := range errs {
+		t.Errorf("concurrent JIT match failed: %s", msg)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	re, err := pcre2.Compile(`(a+)+$`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	defer re.Free()
+
+	subject := strings.Repeat("a", 24) + "b"
+
+	ok, err := re.MatchStringWithLimits(subject, pcre2.Limits{Match: 1000})
+	if !assert.True(t, errors.Is(err, pcre2.ErrMatchLimit), "match limit is reported (got %v)", err) {
+		return
+	}
+	if !assert.False(t, ok, "match fails") {
+		return
+	}
+
+	_, err = re.MatchWithLimits([]byte(subject), pcre2.Limits{Depth: 5})
+	if !assert.True(t, errors.Is(err, pcre2.ErrDepthLimit), "depth limit is reported (got %v)", err) {
+		return
+	}
+
+	ok, err = re.MatchStringWithLimits("aaa", pcre2.Limits{Match: 1000})
+	if !assert.NoError(t, err, "small subject does not hit the limit") {
+		return
+	}
+	if !assert.True(t, ok, "match succeeds") {
+		return
+	}
+
+	ok, err = re.MatchStringWithLimits("bbb", pcre2.Limits{Match: 1000})
+	if !assert.NoError(t, err, "no match is not an error") {
+		return
+	}
+	if !assert.False(t, ok, "match fails") {
+		return
+	}
+
+	re.SetLimits(pcre2.Limits{Match: 1000})
+	_, err = re.FindAllStringIndexWithLimits(subject, -1, pcre2.Limits{})
+	if !assert.True(t, errors.Is(err, pcre2.ErrMatchLimit), "per-Regexp limit is applied (got %v)", err) {
+		return
+	}
+	re.SetLimits(pcre2.Limits{})
+
+	pcre2.SetDefaultLimits(pcre2.Limits{Match: 1000})
+	defer pcre2.SetDefaultLimits(pcre2.Limits{})
+
+	_, err = re.FindAllIndexWithLimits([]byte(subject), -1, pcre2.Limits{})
+	if !assert.True(t, errors.Is(err, pcre2.ErrMatchLimit), "default limit is applied (got %v)", err) {
+		return
+	}
+
+	_, err = re.FindAllIndexWithLimits([]byte("aaaa aaaa"), -1, pcre2.Limits{Match: 1})
+	if !assert.True(t, errors.Is(err, pcre2.ErrMatchLimit), "per-call limit overrides the default (got %v)", err) {
+		return
+	}
+}
+
+func TestContext(t *testing.T) {
+	re, err := pcre2.Compile(`(a+)+$`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	defer re.Free()
+
+	// Make sure we hit the deadline long before any match limit
+	re.SetLimits(pcre2.Limits{Match: 4000000000})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	subject := strings.Repeat("a", 40) + "b"
+	start := time.Now()
+	ok, err := re.MatchStringContext(ctx, subject)
+	if !assert.Equal(t, context.DeadlineExceeded, err, "MatchStringContext is aborted") {
+		return
+	}
+	if !assert.False(t, ok, "MatchStringContext fails") {
+		return
+	}
+	if !assert.True(t, time.Since(start) < 5*time.Second, "MatchStringContext returns promptly") {
+		return
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = re.FindAllIndexContext(ctx, []byte("aaa"), -1)
+	if !assert.Equal(t, context.Canceled, err, "FindAllIndexContext fails on a cancelled context") {
+		return
+	}
+
+	pattern := `(\S+):(\S+)`
+	gore := regexp.MustCompile(pattern)
+	re2 := pcre2.MustCompile(pattern)
+	defer re2.Free()
+
+	subject = `Alice:35 Bob:42 Charlie:21`
+	all, err := re2.FindAllStringSubmatchIndexContext(context.Background(), subject, -1)
+	if !assert.NoError(t, err, "FindAllStringSubmatchIndexContext works") {
+		return
+	}
+	if !assert.Equal(t, gore.FindAllStringSubmatchIndex(subject, -1), all, "indices should match") {
+		return
+	}
+
+	counted, err := pcre2.Compile(`(*NO_START_OPT)(?:a+(?C1))+b`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	defer counted.Free()
+
+	calls := 0
+	counted.SetCallout(func(*pcre2.Callout) pcre2.CalloutAction {
+		calls++
+		return pcre2.CalloutContinue
+	})
+	subject = strings.Repeat("a", 18) + "c"
+	counted.MatchString(subject)
+	expected := calls
+
+	calls = 0
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := counted.MatchStringContext(ctx, subject); !assert.NoError(t, err, "MatchStringContext works") {
+		return
+	}
+	if !assert.True(t, expected > 0, "callouts are called") || !assert.Equal(t, expected, calls, "callouts are not repeated") {
+		return
+	}
+
+	counted.SetCallout(func(*pcre2.Callout) pcre2.CalloutAction {
+		cancel()
+		return pcre2.CalloutContinue
+	})
+	if _, err := counted.MatchStringContext(ctx, subject); !assert.Equal(t, context.Canceled, err, "ctx is checked at callouts") {
+		return
+	}
+}
+
+func TestCallout(t *testing.T) {
+	re, err := pcre2.Compile(`(友+)(?C2)(?C"name")!`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	defer re.Free()
+
+	var callouts []pcre2.Callout
+	re.SetCallout(func(c *pcre2.Callout) pcre2.CalloutAction {
+		callouts = append(callouts, *c)
+		return pcre2.CalloutContinue
+	})
+
+	if !assert.True(t, re.MatchString("a友友!"), "MatchString succeeds") {
+		return
+	}
+	if !assert.Len(t, callouts, 2, "callout function is called twice") {
+		return
+	}
+
+	if !assert.Equal(t, 2, callouts[0].Number, "callout number matches") {
+		return
+	}
+	if !assert.Equal(t, "", callouts[0].String, "numbered callout has no string") {
+		return
+	}
+	if !assert.Equal(t, 1, callouts[0].StartMatch, "start of match is a byte offset") {
+		return
+	}
+	if !assert.Equal(t, 7, callouts[0].SubjectPosition, "subject position is a byte offset") {
+		return
+	}
+	if !assert.Equal(t, []int{1, 7, 1, 7}, callouts[0].Captures, "captures so far match") {
+		return
+	}
+	if !assert.Equal(t, "name", callouts[1].String, "callout string matches") {
+		return
+	}
+	if !assert.Equal(t, 0, callouts[1].Number, "string callout has no number") {
+		return
+	}
+
+	re.SetCallout(func(c *pcre2.Callout) pcre2.CalloutAction {
+		return pcre2.CalloutFail
+	})
+	if !assert.False(t, re.MatchString("a友友!"), "CalloutFail fails the match") {
+		return
+	}
+
+	re.SetCallout(func(c *pcre2.Callout) pcre2.CalloutAction {
+		return pcre2.CalloutAbort
+	})
+	_, err = re.MatchStringWithLimits("a友友!", pcre2.Limits{})
+	if !assert.True(t, errors.Is(err, pcre2.ErrCalloutAbort), "CalloutAbort aborts the match (got %v)", err) {
+		return
+	}
+
+	re.SetCallout(func(c *pcre2.Callout) pcre2.CalloutAction {
+		panic("boom")
+	})
+	if !assert.PanicsWithValue(t, "boom", func() { re.MatchString("a友友!") }, "panic is propagated") {
+		return
+	}
+
+	re.SetCallout(nil)
+	if !assert.True(t, re.MatchString("a友友!"), "MatchString succeeds without callout") {
+		return
+	}
+
+	// Free does not wait for the match that calls the callout function,
+	// which may still use the Regexp
+	freed := make(chan struct{})
+	nested := true
+	re.SetCallout(func(c *pcre2.Callout) pcre2.CalloutAction {
+		if c.Number != 2 {
+			return pcre2.CalloutContinue
+		}
+		go func() {
+			re.Free()
+			close(freed)
+		}()
+		select {
+		case <-freed:
+		case <-time.After(5 * time.Second):
+			return pcre2.CalloutAbort
+		}
+		nested = re.MatchString("友!")
+		return pcre2.CalloutContinue
+	})
+	if !assert.True(t, re.MatchString("a友友!"), "the match in progress finishes after Free") {
+		return
+	}
+	if !assert.False(t, nested, "matches started after Free fail") {
+		return
+	}
+}
+
+func TestReplaceAll(t *testing.T) {
+	data := []string{`Alice:35 Bob:42 Charlie:21`, `桃:三年 栗:三年 柿:八年`, `vini:came vidi:saw vici:won`, ``, `nothing to see`, `baaac`, `baab`}
+	templates := []string{`$2=$1`, `${value}<-$1`, `$1x`, `${1}x`, `$$1`, `$`, `${`, `${1`, `$3`, `$nope`, `[$0]`, `$01`, `${01}`, `$1$`, `$${1}`, `${1x}`, `$日本`, `$value-`}
+	// The patterns that can match the empty string must not match right
+	// after a previous match
+	for _, pattern := range []string{`(\S+):(?P<value>\S+)`, `a*`, `x*`, `a|`} {
+		gore, err := regexp.Compile(pattern)
+		if !assert.NoError(t, err, "Compile works (Go)") {
+			return
+		}
+
+		re, err := pcre2.Compile(pattern)
+		if !assert.NoError(t, err, "Compile works (pcre2)") {
+			return
+		}
+		defer re.Free()
+
+		for _, subject := range data {
+			for _, repl := range templates {
+				t.Logf(`%s: ReplaceAllString("%s", "%s")`, pattern, subject, repl)
+				if !assert.Equal(t, gore.ReplaceAllString(subject, repl), re.ReplaceAllString(subject, repl), "ReplaceAllString should match") {
+					return
+				}
+				if !assert.Equal(t, gore.ReplaceAll([]byte(subject), []byte(repl)), re.ReplaceAll([]byte(subject), []byte(repl)), "ReplaceAll should match") {
+					return
+				}
+				if !assert.Equal(t, gore.ReplaceAllLiteralString(subject, repl), re.ReplaceAllLiteralString(subject, repl), "ReplaceAllLiteralString should match") {
+					return
+				}
+				if !assert.Equal(t, gore.ReplaceAllLiteral([]byte(subject), []byte(repl)), re.ReplaceAllLiteral([]byte(subject), []byte(repl)), "ReplaceAllLiteral should match") {
+					return
+				}
+			}
+
+			t.Logf(`%s: ReplaceAllStringFunc("%s")`, pattern, subject)
+			if !assert.Equal(t, gore.ReplaceAllStringFunc(subject, strings.ToUpper), re.ReplaceAllStringFunc(subject, strings.ToUpper), "ReplaceAllStringFunc should match") {
+				return
+			}
+			if !assert.Equal(t, gore.ReplaceAllFunc([]byte(subject), bytes.ToUpper), re.ReplaceAllFunc([]byte(subject), bytes.ToUpper), "ReplaceAllFunc should match") {
+				return
+			}
+		}
+	}
+
+	// Invalid bytes outside of matches are kept as they are
+	gore := regexp.MustCompile(`a*`)
+	re := pcre2.MustCompile(`a*`)
+	defer re.Free()
+	if !assert.NoError(t, re.SetInvalidUTF8Mode(pcre2.InvalidUTF8Replace), "SetInvalidUTF8Mode works") {
+		return
+	}
+	subject := "b\xffaac"
+	if !assert.Equal(t, gore.ReplaceAllString(subject, `<$0>`), re.ReplaceAllString(subject, `<$0>`), "ReplaceAllString should match") {
+		return
+	}
+}
+
+func TestSubstitute(t *testing.T) {
+	re, err := pcre2.Compile(`(?<name>\S+):(\S+)`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	defer re.Free()
+
+	subject := `alice:35 bob:42 友達:21`
+
+	out, count, err := re.Substitute(subject, `${name}=$2`, 0)
+	if !assert.NoError(t, err, "Substitute works") {
+		return
+	}
+	if !assert.Equal(t, `alice=35 bob:42 友達:21`, out, "only the first match is replaced") {
+		return
+	}
+	if !assert.Equal(t, 1, count, "one replacement is made") {
+		return
+	}
+
+	out, count, err = re.Substitute(subject, `\u${name}=$2`, pcre2.SubstituteGlobal|pcre2.SubstituteExtended)
+	if !assert.NoError(t, err, "Substitute works") {
+		return
+	}
+	if !assert.Equal(t, `Alice=35 Bob=42 友達=21`, out, "case conversion is applied") {
+		return
+	}
+	if !assert.Equal(t, 3, count, "three replacements are made") {
+		return
+	}
+
+	out, _, err = re.Substitute(subject, `${3:+yes:no}`, pcre2.SubstituteGlobal|pcre2.SubstituteExtended|pcre2.SubstituteUnknownUnset)
+	if !assert.NoError(t, err, "Substitute works") {
+		return
+	}
+	if !assert.Equal(t, `no no no`, out, "conditional substitution is applied") {
+		return
+	}
+
+	out, _, err = re.Substitute(subject, `$1`, pcre2.SubstituteGlobal|pcre2.SubstituteLiteral)
+	if !assert.NoError(t, err, "Substitute works") {
+		return
+	}
+	if !assert.Equal(t, `$1 $1 $1`, out, "literal substitution is applied") {
+		return
+	}
+
+	_, _, err = re.Substitute(subject, `友達 ${name`, 0)
+	if !assert.Error(t, err, "Substitute fails for bad replacement") {
+		return
+	}
+	t.Logf("%s", err)
+
+	serr, ok := err.(pcre2.ErrSubstitute)
+	if !assert.True(t, ok, "error is ErrSubstitute") {
+		return
+	}
+	if !assert.Equal(t, len(`友達 ${name`), serr.Offset(), "offset is a byte offset") {
+		return
+	}
+}
+
+func TestSubexpNames(t *testing.T) {
+	patterns := []string{
+		`(\S+):(\S+)`,
+		`(?P<key>\S+):(?P<value>\S+)`,
+		`(?P<first>a)(b)(?:c)(?P<last_1>d)?`,
+		`abc`,
+	}
+	for _, pattern := range patterns {
+		t.Logf("Pattern %s", pattern)
+
+		gore := regexp.MustCompile(pattern)
+		re, err := pcre2.Compile(pattern)
+		if !assert.NoError(t, err, "Compile works") {
+			return
+		}
+		defer re.Free()
+
+		if !assert.Equal(t, gore.NumSubexp(), re.NumSubexp(), "NumSubexp should match") {
+			return
+		}
+		if !assert.Equal(t, gore.SubexpNames(), re.SubexpNames(), "SubexpNames should match") {
+			return
+		}
+		for _, name := range append(gore.SubexpNames(), "missing") {
+			if !assert.Equal(t, gore.SubexpIndex(name), re.SubexpIndex(name), "SubexpIndex(%q) should match", name) {
+				return
+			}
+		}
+	}
+}
+
+func TestDuplicateNames(t *testing.T) {
+	re, err := pcre2.CompileWithOptions(`(?<date>\d{4}-\d\d)|(?<date>\d\d/\d\d/\d{4})|(?<time>\d\d:\d\d)`, pcre2.DupNames)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	defer re.Free()
+
+	if !assert.Equal(t, []int{1, 2}, re.SubexpIndices("date"), "SubexpIndices(date) lists both groups") {
+		return
+	}
+	if !assert.Equal(t, []int{3}, re.SubexpIndices("time"), "SubexpIndices(time) lists one group") {
+		return
+	}
+	if !assert.Nil(t, re.SubexpIndices("missing"), "SubexpIndices(missing) is nil") {
+		return
+	}
+	if !assert.Equal(t, 1, re.SubexpIndex("date"), "SubexpIndex(date) returns the first group") {
+		return
+	}
+	if !assert.Equal(t, []string{"", "date", "date", "time"}, re.SubexpNames(), "SubexpNames lists duplicates") {
+		return
+	}
+
+	m := re.FindStringMatch("due 2016-03")
+	if !assert.NotNil(t, m, "FindStringMatch succeeds") {
+		return
+	}
+	if !assert.Equal(t, "2016-03", m.Named("date"), "Named(date) uses the first group") {
+		return
+	}
+
+	m = re.FindMatch([]byte("due 03/14/2016"))
+	if !assert.NotNil(t, m, "FindMatch succeeds") {
+		return
+	}
+	if !assert.Equal(t, "03/14/2016", m.Named("date"), "Named(date) uses the group that matched") {
+		return
+	}
+	if !assert.Equal(t, "", m.Named("time"), "Named(time) is empty") {
+		return
+	}
+
+	if !assert.Equal(t, []string{"03/14/2016", "", "03/14/2016", ""}, re.FindStringSubmatch("due 03/14/2016"), "unset groups are empty") {
+		return
+	}
+	if !assert.Equal(t, []int{4, 14, -1, -1, 4, 14, -1, -1}, re.FindStringSubmatchIndex("due 03/14/2016"), "unset groups are reported as -1") {
+		return
+	}
+
+	if !assert.Nil(t, re.FindStringMatch("nothing here"), "FindStringMatch fails") {
+		return
+	}
+
+	re.Free()
+	if !assert.Equal(t, 3, re.NumSubexp(), "NumSubexp works after Free") {
+		return
+	}
+	if !assert.Equal(t, []string{"", "date", "date", "time"}, re.SubexpNames(), "SubexpNames works after Free") {
+		return
+	}
+}
+
+func TestMatch(t *testing.T) {
+	re, err := pcre2.Compile(`(?<key>\S+):(?<value>\S+)(?<comment> #.*)?(*MARK:done)`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	defer re.Free()
+
+	subject := `桃:三年 栗:三年 柿:八年 #long`
+	gore := regexp.MustCompile(`(?P<key>\S+):(?P<value>\S+)(?P<comment> #.*)?`)
+	expected := gore.FindAllStringSubmatchIndex(subject, -1)
+
+	for _, doString := range []bool{true, false} {
+		var all []*pcre2.Match
+		if doString {
+			all = re.FindAllStringMatches(subject, -1)
+		} else {
+			all = re.FindAllMatches([]byte(subject), -1)
+		}
+		if !assert.Len(t, all, len(expected), "number of matches should match") {
+			return
+		}
+
+		for i, m := range all {
+			for g := 0; g < 4; g++ {
+				var is []int
+				if expected[i][2*g] >= 0 {
+					is = expected[i][2*g : 2*g+2]
+				}
+				if !assert.Equal(t, is, m.GroupIndex(g), "GroupIndex(%d) should match", g) {
+					return
+				}
+				if !assert.Equal(t, is == nil, m.Unset(g), "Unset(%d) should match", g) {
+					return
+				}
+			}
+			if !assert.Equal(t, expected[i][0], m.Start(), "Start should match") {
+				return
+			}
+			if !assert.Equal(t, expected[i][1], m.End(), "End should match") {
+				return
+			}
+			if !assert.Equal(t, "done", m.Mark(), "Mark should match") {
+				return
+			}
+		}
+	}
+
+	m := re.FindStringMatch(subject)
+	if !assert.NotNil(t, m, "FindStringMatch succeeds") {
+		return
+	}
+	if !assert.Equal(t, "桃:三年", m.Group(0), "Group(0) is the whole match") {
+		return
+	}
+	if !assert.Equal(t, "三年", m.Group(2), "Group(2) matches") {
+		return
+	}
+	if !assert.True(t, m.Unset(3), "Unset(3) is true") {
+		return
+	}
+	if !assert.True(t, m.Unset(4), "Unset(4) is true for a missing group") {
+		return
+	}
+	if !assert.Equal(t, "", m.Group(4), "Group(4) is empty for a missing group") {
+		return
+	}
+	if !assert.Equal(t, map[string]string{"key": "桃", "value": "三年", "comment": ""}, m.NamedMap(), "NamedMap matches") {
+		return
+	}
+
+	all := re.FindAllStringMatches(subject, 2)
+	if !assert.Len(t, all, 2, "FindAllStringMatches honors n") {
+		return
+	}
+	if !assert.Equal(t, "栗", all[1].Named("key"), "Named(key) matches") {
+		return
+	}
+}
+
+func TestUTF8(t *testing.T) {
+	re, err := pcre2.Compile(`年(.)`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	defer re.Free()
+
+	subject := "桃:三年 栗:三年"
+	if !assert.Equal(t, [][]int{{7, 11, 10, 11}, {18, 22, 21, 22}}, re.FindAllStringSubmatchIndex(subject+" ", -1), "offsets are byte offsets") {
+		return
+	}
+
+	if _, err := pcre2.Compile("\xff"); !assert.Equal(t, pcre2.ErrInvalidUTF8String, err, "Compile fails for invalid UTF-8") {
+		return
+	}
+
+	invalid := []byte("年a\xff")
+	for _, jit := range []bool{false, true} {
+		if jit {
+			if !pcre2.JITSupported() {
+				continue
+			}
+			if !assert.NoError(t, re.JIT(), "JIT works") {
+				return
+			}
+		}
+
+		if !assert.False(t, re.Match(invalid), "Match fails for invalid UTF-8 (jit = %t)", jit) {
+			return
+		}
+		if _, err := re.MatchWithLimits(invalid, pcre2.Limits{}); !assert.True(t, errors.Is(err, pcre2.ErrInvalidUTF8String), "MatchWithLimits reports invalid UTF-8 (jit = %t, got %v)", jit, err) {
+			return
+		}
+	}
+}
+
+func TestFindAllEmptyMatches(t *testing.T) {
+	patterns := []string{`x*`, `a*`, `(a)|b*`, `(?:)`, `,?`, `[^,]*`, `(\w*)`, `a*?`, `|a`}
+	data := []string{``, `abc`, `baaac`, `a,b,,c`, `日本,語`, `aaa`, `aa`}
+	for _, pattern := range patterns {
+		gore, err := regexp.Compile(pattern)
+		if !assert.NoError(t, err, "Compile works (Go)") {
+			return
+		}
+
+		re, err := pcre2.Compile(pattern)
+		if !assert.NoError(t, err, "Compile works (pcre2)") {
+			return
+		}
+		defer re.Free()
+
+		for _, jit := range []bool{false, true} {
+			if jit {
+				if !pcre2.JITSupported() {
+					continue
+				}
+				if !assert.NoError(t, re.JIT(), "JIT works") {
+					return
+				}
+			}
+
+			for _, subject := range data {
+				for _, n := range []int{-1, 0, 1, 2} {
+					t.Logf(`FindAll("%s", "%s", %d) (jit = %t)`, pattern, subject, n, jit)
+					if !assert.Equal(t, gore.FindAllStringIndex(subject, n), re.FindAllStringIndex(subject, n), "FindAllStringIndex should match") {
+						return
+					}
+					if !assert.Equal(t, gore.FindAllString(subject, n), re.FindAllString(subject, n), "FindAllString should match") {
+						return
+					}
+					if !assert.Equal(t, gore.FindAllIndex([]byte(subject), n), re.FindAllIndex([]byte(subject), n), "FindAllIndex should match") {
+						return
+					}
+					if !assert.Equal(t, gore.FindAllStringSubmatchIndex(subject, n), re.FindAllStringSubmatchIndex(subject, n), "FindAllStringSubmatchIndex should match") {
+						return
+					}
+				}
+			}
+		}
+	}
+
+	// CRLF is a single character when it is a valid newline sequence,
+	// so no empty match is found between CR and LF
+	re, err := pcre2.Compile(`(*ANYCRLF)x*`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	defer re.Free()
+
+	if !assert.Equal(t, [][]int{{0, 0}, {1, 1}, {3, 3}, {4, 4}}, re.FindAllStringIndex("a\r\nb", -1), "CRLF is skipped as a whole") {
+		return
+	}
+}
+
+func TestFindAllContext(t *testing.T) {
+	// Later matches must see the text before them, so these agree
+	// with the regexp package
+	for _, pattern := range []string{`^a`, `a|\bfoo`, `\Bo`, `(?m)^\w`, `a$|b`} {
+		gore, err := regexp.Compile(pattern)
+		if !assert.NoError(t, err, "Compile works (Go)") {
+			return
+		}
+
+		re, err := pcre2.Compile(pattern)
+		if !assert.NoError(t, err, "Compile works (pcre2)") {
+			return
+		}
+		defer re.Free()
+
+		for _, subject := range []string{`aa`, `afoo foo`, `foo boo`, "ab\ncd", `aba`} {
+			t.Logf(`FindAllStringIndex("%s", "%s")`, pattern, subject)
+			if !assert.Equal(t, gore.FindAllStringIndex(subject, -1), re.FindAllStringIndex(subject, -1), "FindAllStringIndex should match") {
+				return
+			}
+		}
+	}
+
+	tests := []struct {
+		pattern  string
+		subject  string
+		expected [][]int
+	}{
+		{`b|(?<=b)c`, `bcbc`, [][]int{{0, 1}, {1, 2}, {2, 3}, {3, 4}}},
+		{`(?<=a)b`, `abb`, [][]int{{1, 2}}},
+		{`(?<!a)b`, `abb`, [][]int{{2, 3}}},
+		{`\Ga`, `aaba`, [][]int{{0, 1}, {1, 2}}},
+		{`\G\d,?`, `1,2,x,3`, [][]int{{0, 2}, {2, 4}}},
+	}
+	for _, test := range tests {
+		re, err := pcre2.Compile(test.pattern)
+		if !assert.NoError(t, err, "Compile works") {
+			return
+		}
+		defer re.Free()
+
+		if !assert.Equal(t, test.expected, re.FindAllStringIndex(test.subject, -1), "FindAllStringIndex(%q, %q) should match", test.pattern, test.subject) {
+			return
+		}
+		if !assert.Len(t, re.FindAllStringMatches(test.subject, -1), len(test.expected), "FindAllStringMatches(%q, %q) should match", test.pattern, test.subject) {
+			return
+		}
+	}
+}
+
+func TestMatchError(t *testing.T) {
+	re, err := pcre2.Compile(`(a+)+$`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+
+	ok, err := re.MatchStringE("aaa")
+	if !assert.NoError(t, err, "MatchStringE works") || !assert.True(t, ok, "MatchStringE matches") {
+		return
+	}
+	ok, err = re.MatchStringE("bbb")
+	if !assert.NoError(t, err, "no match is not an error") || !assert.False(t, ok, "MatchStringE does not match") {
+		return
+	}
+	is, err := re.FindStringSubmatchIndexE("xaa")
+	if !assert.NoError(t, err, "FindStringSubmatchIndexE works") || !assert.Equal(t, []int{1, 3, 1, 3}, is, "FindStringSubmatchIndexE matches") {
+		return
+	}
+
+	_, err = re.MatchE([]byte("a\xff"))
+	var merr pcre2.MatchError
+	if !assert.True(t, errors.As(err, &merr), "invalid UTF-8 is a MatchError (got %v)", err) {
+		return
+	}
+	if !assert.True(t, merr.Code() < 0, "Code is a PCRE2 error code") {
+		return
+	}
+	if !assert.True(t, errors.Is(err, pcre2.ErrInvalidUTF8String), "MatchError matches ErrInvalidUTF8String") {
+		return
+	}
+	if !assert.False(t, errors.Is(err, pcre2.ErrMatchLimit), "MatchError does not match ErrMatchLimit") {
+		return
+	}
+	if !assert.Contains(t, err.Error(), "UTF-8", "Error includes the PCRE2 message") {
+		return
+	}
+
+	re.SetLimits(pcre2.Limits{Match: 1000})
+	all, err := re.FindAllStringIndexE("aa "+strings.Repeat("a", 30)+"b", -1)
+	if !assert.True(t, errors.Is(err, pcre2.ErrMatchLimit), "FindAllStringIndexE reports the match limit (got %v)", err) {
+		return
+	}
+	if !assert.Nil(t, all, "no matches were found before the error") {
+		return
+	}
+
+	re.Free()
+	if _, err := re.MatchStringE("aaa"); !assert.Equal(t, pcre2.ErrInvalidRegexp, err, "MatchStringE fails after Free") {
+		return
+	}
+	if _, err := re.FindAllIndexE([]byte("aaa"), -1); !assert.Equal(t, pcre2.ErrInvalidRegexp, err, "FindAllIndexE fails after Free") {
+		return
+	}
+}
+
+func TestInvalidUTF8Mode(t *testing.T) {
+	subject := "caf\xe9 latte \xff\xfe"
+
+	re, err := pcre2.Compile(`caf.|\w+`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	defer re.Free()
+
+	if pcre2.JITSupported() {
+		if !assert.NoError(t, re.JIT(), "JIT works") {
+			return
+		}
+	}
+
+	if !assert.Equal(t, pcre2.InvalidUTF8Strict, re.InvalidUTF8Mode(), "strict mode is the default") {
+		return
+	}
+	if !assert.False(t, re.MatchString(subject), "MatchString fails in strict mode") {
+		return
+	}
+	if _, err := re.FindAllStringIndexE(subject, -1); !assert.True(t, errors.Is(err, pcre2.ErrInvalidUTF8String), "FindAllStringIndexE reports invalid UTF-8 (got %v)", err) {
+		return
+	}
+
+	if !assert.NoError(t, re.SetInvalidUTF8Mode(pcre2.InvalidUTF8Replace), "SetInvalidUTF8Mode(InvalidUTF8Replace) works") {
+		return
+	}
+	if !assert.True(t, re.MatchString(subject), "MatchString succeeds in replace mode") {
+		return
+	}
+	if !assert.Equal(t, [][]int{{0, 4}, {5, 10}}, re.FindAllStringIndex(subject, -1), "offsets are against the original subject") {
+		return
+	}
+	if !assert.Equal(t, []string{"caf\xe9", "latte"}, re.FindAllString(subject, -1), "FindAllString returns the original bytes") {
+		return
+	}
+	if m := re.FindStringMatch(subject); !assert.NotNil(t, m, "FindStringMatch works") || !assert.Equal(t, "caf\xe9", m.Group(0), "Group(0) returns the original bytes") {
+		return
+	}
+	if !assert.Equal(t, "X X \xff\xfe", re.ReplaceAllLiteralString(subject, "X"), "ReplaceAllLiteralString keeps the invalid bytes") {
+		return
+	}
+
+	fffd, err := pcre2.Compile(`\x{fffd}`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	defer fffd.Free()
+
+	if !assert.NoError(t, fffd.SetInvalidUTF8Mode(pcre2.InvalidUTF8Replace), "SetInvalidUTF8Mode(InvalidUTF8Replace) works") {
+		return
+	}
+	if !assert.Equal(t, [][]int{{3, 4}, {11, 12}, {12, 13}}, fffd.FindAllStringIndex(subject, -1), "each invalid byte is a U+FFFD") {
+		return
+	}
+
+	if !assert.NoError(t, re.SetInvalidUTF8Mode(pcre2.InvalidUTF8Skip), "SetInvalidUTF8Mode(InvalidUTF8Skip) works") {
+		return
+	}
+	if !assert.Equal(t, pcre2.InvalidUTF8Skip, re.InvalidUTF8Mode(), "InvalidUTF8Mode returns the mode") {
+		return
+	}
+	if pcre2.JITSupported() && !assert.True(t, re.JITCompiled(pcre2.JITComplete), "JIT compilation is kept") {
+		return
+	}
+	if !assert.Equal(t, [][]int{{0, 3}, {5, 10}}, re.FindAllStringIndex(subject, -1), "invalid bytes are skipped") {
+		return
+	}
+
+	empty, err := pcre2.Compile(`x*`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	defer empty.Free()
+
+	if !assert.NoError(t, empty.SetInvalidUTF8Mode(pcre2.InvalidUTF8Skip), "SetInvalidUTF8Mode(InvalidUTF8Skip) works") {
+		return
+	}
+	if !assert.Equal(t, [][]int{{0, 0}, {1, 1}, {2, 2}, {3, 3}, {4, 4}, {5, 5}}, empty.FindAllStringIndex("ab\xffcd", -1), "empty matches are found before an invalid byte") {
+		return
+	}
+
+	if !assert.NoError(t, re.SetInvalidUTF8Mode(pcre2.InvalidUTF8Strict), "SetInvalidUTF8Mode(InvalidUTF8Strict) works") {
+		return
+	}
+	if !assert.False(t, re.MatchString(subject), "MatchString fails in strict mode again") {
+		return
+	}
+}
+
+func TestFree(t *testing.T) {
+	re, err := pcre2.Compile(`^Hello (.+)!$`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+
+	var closer io.Closer = re
+	if !assert.NoError(t, closer.Close(), "Close works") {
+		return
+	}
+	if !assert.NoError(t, re.Free(), "Free after Close does nothing") {
+		return
+	}
+	if !assert.NoError(t, re.Free(), "Free is idempotent") {
+		return
+	}
+	if !assert.False(t, re.MatchString("Hello World!"), "MatchString fails after Free") {
+		return
+	}
+
+	var nilre *pcre2.Regexp
+	if !assert.Equal(t, pcre2.ErrInvalidRegexp, nilre.Free(), "Free on a nil Regexp fails") {
+		return
+	}
+}
+
+func TestLeakDetector(t *testing.T) {
+	leaks := make(chan string, 10)
+	pcre2.SetLeakDetector(func(pattern string, stack []byte) {
+		if strings.Contains(string(stack), "compileAndForget") {
+			leaks <- pattern
+		}
+	})
+	defer pcre2.SetLeakDetector(nil)
+
+	compileAndForget(t, `leaked`)
+	freed, err := pcre2.Compile(`freed`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	freed.Free()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		runtime.GC()
+		select {
+		case pattern := <-leaks:
+			if !assert.Equal(t, `leaked`, pattern, "leaked Regexp is reported") {
+				return
+			}
+			return
+		case <-timeout:
+			t.Errorf("leaked Regexp was not reported")
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func compileAndForget(t *testing.T, pattern string) {
+	re, err := pcre2.Compile(pattern)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	if !assert.True(t, re.MatchString(pattern), "MatchString works") {
+		return
+	}
+}
+
+func TestConcurrentFree(t *testing.T) {
+	subject := strings.Repeat("Alice:35 Bob:42 桃:三年 ", 50)
+	expected := strings.Count(subject, ":")
+
+	for i := 0; i < 10; i++ {
+		re, err := pcre2.Compile(`(\S+):(\S+)`)
+		if !assert.NoError(t, err, "Compile works") {
+			return
+		}
+		if pcre2.JITSupported() && i%2 == 0 {
+			if !assert.NoError(t, re.JIT(), "JIT works") {
+				return
+			}
+		}
+
+		var wg sync.WaitGroup
+		errs := make(chan string, 100)
+		start := make(chan struct{})
+		for j := 0; j < 8; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				for {
+					all, err := re.FindAllStringSubmatchIndexE(subject, -1)
+					if err != nil {
+						if err != pcre2.ErrInvalidRegexp {
+							errs <- err.Error()
+						}
+						return
+					}
+					if len(all) != expected {
+						errs <- "unexpected number of matches"
+						return
+					}
+					if ok, err := re.MatchStringE(subject); err == nil && !ok {
+						errs <- "expected match"
+						return
+					}
+					re.ReplaceAllString(subject, "$2:$1")
+					re.FindAllStringMatches(subject, 3)
+				}
+			}()
+		}
+
+		close(start)
+		time.Sleep(time.Millisecond)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			re.Free()
+		}()
+		wg.Wait()
+		close(errs)
+
+		for msg := range errs {
+			t.Errorf("concurrent Free failed: %s", msg)
+		}
+		if !assert.False(t, re.MatchString(subject), "MatchString fails after Free") {
+			return
+		}
+	}
+}
+
+func TestConcurrentSetters(t *testing.T) {
+	re, err := pcre2.Compile(`(\S+):(?C1)(\S+)`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	defer re.Free()
+
+	subject := strings.Repeat("Alice:35 Bob:42 桃:三年 ", 20)
+	expected := strings.Count(subject, ":")
+
+	var wg sync.WaitGroup
+	errs := make(chan string, 100)
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := re.NewMatcher()
+			defer m.Free()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if len(re.FindAllStringSubmatchIndex(subject, -1)) != expected {
+					errs <- "unexpected number of matches"
+					return
+				}
+				if !m.MatchString(subject) {
+					errs <- "expected match"
+					return
+				}
+			}
+		}()
+	}
+
+	// The setters wait for the matches that are in progress
+	for i := 0; i < 50; i++ {
+		re.SetLimits(pcre2.Limits{Match: uint32(1000000 + i)})
+		re.SetCallout(func(*pcre2.Callout) pcre2.CalloutAction {
+			return pcre2.CalloutContinue
+		})
+		re.SetCallout(nil)
+		time.Sleep(time.Millisecond)
+	}
+	close(done)
+	wg.Wait()
+	close(errs)
+
+	for msg := range errs {
+		t.Errorf("match during setters failed: %s", msg)
+	}
+	if !assert.Equal(t, pcre2.Limits{Match: 1000049}, re.Limits(), "Limits returns the last limits") {
+		return
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	re, err := pcre2.Compile(`(\S+):(\S+)?`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	defer re.Free()
+
+	m := re.NewMatcher()
+	defer m.Free()
+
+	var closer io.Closer = m
+	_ = closer
+
+	for _, subject := range []string{`Alice:35 Bob:42`, `桃:三年 栗:三年`, `nothing to see`, `empty: `, ``} {
+		if !assert.Equal(t, re.MatchString(subject), m.MatchString(subject), "MatchString should match (%q)", subject) {
+			return
+		}
+		if !assert.Equal(t, re.Match([]byte(subject)), m.Match([]byte(subject)), "Match should match (%q)", subject) {
+			return
+		}
+		if !assert.Equal(t, re.FindStringIndex(subject), m.FindStringIndex(subject), "FindStringIndex should match (%q)", subject) {
+			return
+		}
+		if !assert.Equal(t, re.FindIndex([]byte(subject)), m.FindIndex([]byte(subject)), "FindIndex should match (%q)", subject) {
+			return
+		}
+		if !assert.Equal(t, re.FindStringSubmatchIndex(subject), m.FindStringSubmatchIndex(subject), "FindStringSubmatchIndex should match (%q)", subject) {
+			return
+		}
+		if !assert.Equal(t, re.FindSubmatchIndex([]byte(subject)), m.FindSubmatchIndex([]byte(subject)), "FindSubmatchIndex should match (%q)", subject) {
+			return
+		}
+	}
+
+	subject := []byte(`Alice:35`)
+	allocs := testing.AllocsPerRun(100, func() {
+		m.Match(subject)
+		m.FindSubmatchIndex(subject)
+	})
+	if !assert.Zero(t, allocs, "Matcher does not allocate") {
+		return
+	}
+
+	if !assert.NoError(t, m.Free(), "Free works") {
+		return
+	}
+	if !assert.NoError(t, m.Free(), "Free is idempotent") {
+		return
+	}
+	if !assert.True(t, m.MatchString(`Alice:35`), "Matcher can be used after Free") {
+		return
+	}
+
+	re.Free()
+	if !assert.False(t, m.MatchString(`Alice:35`), "Matcher fails after the Regexp is freed") {
+		return
+	}
+}
+
+func TestSerializeRegexps(t *testing.T) {
+	named, err := pcre2.Compile(`(?<key>\w+)=(?<value>\w+)`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	defer named.Free()
+
+	caseless, err := pcre2.CompileWithOptions(`hello`, pcre2.Caseless)
+	if !assert.NoError(t, err, "CompileWithOptions works") {
+		return
+	}
+	defer caseless.Free()
+
+	skip, err := pcre2.Compile(`\w+`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	defer skip.Free()
+	if !assert.NoError(t, skip.SetInvalidUTF8Mode(pcre2.InvalidUTF8Skip), "SetInvalidUTF8Mode works") {
+		return
+	}
+	if pcre2.JITSupported() {
+		if !assert.NoError(t, skip.JIT(), "JIT works") {
+			return
+		}
+	}
+
+	data, err := pcre2.SerializeRegexps([]*pcre2.Regexp{named, caseless, skip, named})
+	if !assert.NoError(t, err, "SerializeRegexps works") {
+		return
+	}
+
+	list, err := pcre2.DeserializeRegexps(data)
+	if !assert.NoError(t, err, "DeserializeRegexps works") {
+		return
+	}
+	for _, re := range list {
+		defer re.Free()
+	}
+	if !assert.Len(t, list, 4, "DeserializeRegexps returns every Regexp") {
+		return
+	}
+
+	for i, orig := range []*pcre2.Regexp{named, caseless, skip, named} {
+		if !assert.Equal(t, orig.String(), list[i].String(), "String should match") {
+			return
+		}
+		if !assert.Equal(t, orig.SubexpNames(), list[i].SubexpNames(), "SubexpNames should match") {
+			return
+		}
+		if !assert.Equal(t, orig.InvalidUTF8Mode(), list[i].InvalidUTF8Mode(), "InvalidUTF8Mode should match") {
+			return
+		}
+		if !assert.Equal(t, orig.JITCompiled(pcre2.JITComplete), list[i].JITCompiled(pcre2.JITComplete), "JITCompiled should match") {
+			return
+		}
+	}
+
+	if !assert.Equal(t, []string{"a=1", "a", "1"}, list[0].FindStringSubmatch(`x a=1`), "FindStringSubmatch works") {
+		return
+	}
+	if !assert.True(t, list[1].MatchString(`HeLLo`), "compile options are kept") {
+		return
+	}
+	if !assert.Equal(t, []int{3, 6}, list[2].FindIndex([]byte("\xff\xfe\xfdabc")), "InvalidUTF8Skip is kept") {
+		return
+	}
+	if !assert.NoError(t, list[0].Free(), "Free works") {
+		return
+	}
+	if !assert.True(t, list[3].MatchString(`a=1`), "deserialized Regexps are independent") {
+		return
+	}
+
+	empty, err := pcre2.SerializeRegexps(nil)
+	if !assert.NoError(t, err, "SerializeRegexps works with no Regexps") {
+		return
+	}
+	list, err = pcre2.DeserializeRegexps(empty)
+	if !assert.NoError(t, err, "DeserializeRegexps works with no Regexps") {
+		return
+	}
+	if !assert.Empty(t, list, "no Regexps are returned") {
+		return
+	}
+
+	// The header holds the code unit width at offset 5, and the
+	// version starting at offset 7
+	for offset, value := range map[int]byte{5: 16, 7: '9'} {
+		bad := append([]byte(nil), data...)
+		bad[offset] = value
+		_, err = pcre2.DeserializeRegexps(bad)
+		var incompatible pcre2.ErrIncompatibleSerializedData
+		if !assert.True(t, errors.As(err, &incompatible), "incompatible data is rejected (%v)", err) {
+			return
+		}
+		t.Logf("%s", err)
+	}
+
+	for _, bad := range [][]byte{nil, []byte(`hello`), data[:len(data)-1], append(append([]byte(nil), data[:len(data)-1]...), data[len(data)-1]^0xff)} {
+		_, err = pcre2.DeserializeRegexps(bad)
+		if !assert.Equal(t, pcre2.ErrInvalidSerializedData, err, "invalid data is rejected") {
+			return
+		}
+	}
+
+	caseless.Free()
+	_, err = pcre2.SerializeRegexps([]*pcre2.Regexp{named, caseless})
+	if !assert.Equal(t, pcre2.ErrInvalidRegexp, err, "freed Regexps cannot be serialized") {
+		return
+	}
+}
+
+func TestInfo(t *testing.T) {
+	info := func(pattern string) pcre2.Info {
+		re, err := pcre2.Compile(pattern)
+		if !assert.NoError(t, err, "Compile works") {
+			return pcre2.Info{}
+		}
+		defer re.Free()
+
+		info, err := re.Info()
+		if !assert.NoError(t, err, "Info works") {
+			return pcre2.Info{}
+		}
+		return info
+	}
+
+	i := info(`(a)(b)\2c`)
+	if !assert.Equal(t, 2, i.CaptureCount, "CaptureCount is 2") {
+		return
+	}
+	if !assert.Equal(t, 2, i.BackRefMax, "BackRefMax is 2") {
+		return
+	}
+
+	i = info(`hello, 世界`)
+	if !assert.Equal(t, 9, i.MinLength, "MinLength counts characters") {
+		return
+	}
+	if !assert.Equal(t, int('h'), i.FirstCodeUnit, "FirstCodeUnit is h") {
+		return
+	}
+	if !assert.Nil(t, i.FirstCodeUnits, "FirstCodeUnits is not set") {
+		return
+	}
+	if !assert.Equal(t, -1, info(`.`).FirstCodeUnit, "FirstCodeUnit is unset") {
+		return
+	}
+	if !assert.Equal(t, -1, info(`.`).LastCodeUnit, "LastCodeUnit is unset") {
+		return
+	}
+	if !assert.Equal(t, int('x'), info(`a+x\d`).LastCodeUnit, "LastCodeUnit is x") {
+		return
+	}
+
+	i = info(`[ab]x|cy`)
+	if !assert.Equal(t, []byte("abc"), i.FirstCodeUnits, "FirstCodeUnits is a, b and c") {
+		return
+	}
+	if !assert.Equal(t, -1, i.FirstCodeUnit, "FirstCodeUnit is unset") {
+		return
+	}
+	if !assert.True(t, info(`(?m)^abc|^def`).StartOfLine, "StartOfLine is set") {
+		return
+	}
+	if !assert.False(t, info(`abc`).StartOfLine, "StartOfLine is not set") {
+		return
+	}
+
+	if !assert.Equal(t, 3, info(`(?<=abc)x`).MaxLookbehind, "MaxLookbehind is 3") {
+		return
+	}
+	if !assert.True(t, info(`a\Cb`).HasBackslashC, "HasBackslashC is set") {
+		return
+	}
+	if !assert.False(t, info(`a\\Cb`).HasBackslashC, "HasBackslashC is not set") {
+		return
+	}
+	if !assert.True(t, info(`a\nb`).HasCRorLF, "HasCRorLF is set") {
+		return
+	}
+	if !assert.False(t, info(`ab`).HasCRorLF, "HasCRorLF is not set") {
+		return
+	}
+
+	if !assert.Equal(t, pcre2.Limits{Match: 100, Depth: 50, Heap: 20}, info(`(*LIMIT_MATCH=100)(*LIMIT_DEPTH=50)(*LIMIT_HEAP=20)a`).Limits, "Limits are set by the pattern") {
+		return
+	}
+	if !assert.Equal(t, pcre2.Limits{}, info(`a`).Limits, "Limits are not set") {
+		return
+	}
+
+	i = info(`(*CRLF)(*BSR_ANYCRLF)a\Rb`)
+	if !assert.Equal(t, pcre2.NewlineCRLF, i.Newline, "Newline is CRLF") {
+		return
+	}
+	if !assert.Equal(t, pcre2.BSRAnyCRLF, i.BSR, "BSR is AnyCRLF") {
+		return
+	}
+	if !assert.Equal(t, pcre2.NewlineAnyCRLF, info(`(*ANYCRLF)a`).Newline, "Newline is AnyCRLF") {
+		return
+	}
+	if !assert.Equal(t, pcre2.BSRUnicode, info(`(*BSR_UNICODE)a`).BSR, "BSR is Unicode") {
+		return
+	}
+
+	re, err := pcre2.Compile(`\d+-\d+`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	defer re.Free()
+
+	i, err = re.Info()
+	if !assert.NoError(t, err, "Info works") {
+		return
+	}
+	if !assert.True(t, i.Size > 0, "Size is set") {
+		return
+	}
+	if !assert.Zero(t, i.JITSize, "JITSize is not set") {
+		return
+	}
+	if pcre2.JITSupported() {
+		if !assert.NoError(t, re.JIT(), "JIT works") {
+			return
+		}
+		i, err = re.Info()
+		if !assert.NoError(t, err, "Info works") {
+			return
+		}
+		if !assert.True(t, i.JITSize > 0, "JITSize is set") {
+			return
+		}
+	}
+
+	re.Free()
+	_, err = re.Info()
+	if !assert.Equal(t, pcre2.ErrInvalidRegexp, err, "Info fails after Free") {
+		return
+	}
+}
+
+func TestPartial(t *testing.T) {
+	for _, jit := range []bool{false, true} {
+		if jit && !pcre2.JITSupported() {
+			continue
+		}
+
+		compile := func(pattern string) *pcre2.Regexp {
+			re, err := pcre2.Compile(pattern)
+			if !assert.NoError(t, err, "Compile works") {
+				return nil
+			}
+			if jit {
+				if !assert.NoError(t, re.JIT(pcre2.JITComplete, pcre2.JITPartialSoft, pcre2.JITPartialHard), "JIT works") {
+					return nil
+				}
+			}
+			return re
+		}
+
+		date := compile(`\d?\d(jan|feb|mar)\d\d`)
+		if date == nil {
+			return
+		}
+		defer date.Free()
+
+		for _, mode := range []pcre2.PartialMode{pcre2.PartialSoft, pcre2.PartialHard} {
+			res, err := date.MatchPartialString(`the date is 23ja`, mode)
+			if !assert.NoError(t, err, "MatchPartialString works") {
+				return
+			}
+			if !assert.Equal(t, pcre2.PartialResult{Status: pcre2.PartialMatch, Index: []int{12, 16}, Keep: 12}, res, "partial match (jit = %t)", jit) {
+				return
+			}
+
+			res, err = date.FindPartial([]byte(`the date is 23ja`), mode)
+			if !assert.NoError(t, err, "FindPartial works") {
+				return
+			}
+			if !assert.Equal(t, []int{12, 16, -1, -1}, res.Index, "groups are not set for partial matches (jit = %t)", jit) {
+				return
+			}
+
+			res, err = date.FindPartialString(`the date is 23jan19 and on that day`, mode)
+			if !assert.NoError(t, err, "FindPartialString works") {
+				return
+			}
+			if !assert.Equal(t, pcre2.PartialResult{Status: pcre2.FullMatch, Index: []int{12, 19, 14, 17}, Keep: -1}, res, "full match (jit = %t)", jit) {
+				return
+			}
+
+			res, err = date.MatchPartial([]byte(`no date here`), mode)
+			if !assert.NoError(t, err, "MatchPartial works") {
+				return
+			}
+			if !assert.Equal(t, pcre2.PartialResult{Status: pcre2.NoMatch, Keep: -1}, res, "no match (jit = %t)", jit) {
+				return
+			}
+		}
+
+		dog := compile(`dog(sbody)?`)
+		if dog == nil {
+			return
+		}
+		defer dog.Free()
+
+		res, err := dog.MatchPartialString(`dog`, pcre2.PartialSoft)
+		if !assert.NoError(t, err, "MatchPartialString works") {
+			return
+		}
+		if !assert.Equal(t, pcre2.FullMatch, res.Status, "soft prefers a full match (jit = %t)", jit) {
+			return
+		}
+		res, err = dog.MatchPartialString(`dog`, pcre2.PartialHard)
+		if !assert.NoError(t, err, "MatchPartialString works") {
+			return
+		}
+		if !assert.Equal(t, pcre2.PartialMatch, res.Status, "hard prefers a partial match (jit = %t)", jit) {
+			return
+		}
+
+		lookbehind := compile(`(?<=日本)語です`)
+		if lookbehind == nil {
+			return
+		}
+		defer lookbehind.Free()
+
+		res, err = lookbehind.MatchPartialString(`それは日本語で`, pcre2.PartialHard)
+		if !assert.NoError(t, err, "MatchPartialString works") {
+			return
+		}
+		if !assert.Equal(t, pcre2.PartialResult{Status: pcre2.PartialMatch, Index: []int{15, 21}, Keep: 9}, res, "Keep includes the lookbehind (jit = %t)", jit) {
+			return
+		}
+	}
+
+	re, err := pcre2.Compile(`abc`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	defer re.Free()
+
+	if pcre2.JITSupported() {
+		if !assert.NoError(t, re.JIT(), "JIT works") {
+			return
+		}
+		res, err := re.MatchPartialString(`ab`, pcre2.PartialHard)
+		if !assert.NoError(t, err, "MatchPartialString works without partial JIT modes") {
+			return
+		}
+		if !assert.Equal(t, pcre2.PartialMatch, res.Status, "the interpreter is used") {
+			return
+		}
+	}
+
+	_, err = re.MatchPartialString(`ab`, 0)
+	if !assert.Equal(t, pcre2.ErrInvalidPartialMode, err, "invalid modes are rejected") {
+		return
+	}
+	_, err = re.MatchPartialString("ab\xff", pcre2.PartialHard)
+	if !assert.True(t, errors.Is(err, pcre2.ErrInvalidUTF8String), "invalid UTF-8 is reported") {
+		return
+	}
+
+	re.Free()
+	_, err = re.MatchPartialString(`ab`, pcre2.PartialHard)
+	if !assert.Equal(t, pcre2.ErrInvalidRegexp, err, "MatchPartialString fails after Free") {
+		return
+	}
+}
+
+func scanAll(t *testing.T, re *pcre2.Regexp, rd io.Reader) ([][]int, error) {
+	var all [][]int
+	s := re.NewScanner(rd)
+	for s.Scan() {
+		index := make([]int, 0, len(s.SubmatchIndex()))
+		for _, v := range s.SubmatchIndex() {
+			index = append(index, int(v))
+		}
+		if !assert.Equal(t, index[:2], []int{int(s.Index()[0]), int(s.Index()[1])}, "Index is the first pair of SubmatchIndex") {
+			return nil, nil
+		}
+		all = append(all, index)
+	}
+	return all, s.Err()
+}
+
+func TestScanner(t *testing.T) {
+	patterns := []string{
+		`\d+`,
+		`a*`,
+		`x*`,
+		`(\w+)@(\w+)`,
+		`\b\w+\b`,
+		`(?<=ab)c`,
+		`(?m)^\w+`,
+		`(?m)\w+$`,
+		`^abc`,
+		`\w+\z`,
+		`日本`,
+		`.`,
+		`(a)|(b)`,
+		`(*CRLF)(?m)^.*$`,
+		`a*?`,
+		`|a`,
+		`\G.`,
+		`\Gx|a`,
+		`(?<=\G.)a`,
+	}
+	subjects := []string{
+		``,
+		`abc`,
+		`abcabc 123 abc`,
+		`foo@bar baz@qux 42`,
+		"line one\nline two\r\nline three\n",
+		`日本語の日本 abc`,
+		`aabaaab`,
+		"xa\na",
+		"x\nx\r\nx",
+	}
+
+	readers := map[string]func(string) io.Reader{
+		"whole":    func(s string) io.Reader { return strings.NewReader(s) },
+		"one byte": func(s string) io.Reader { return iotest.OneByteReader(strings.NewReader(s)) },
+		"half":     func(s string) io.Reader { return iotest.HalfReader(strings.NewReader(s)) },
+	}
+
+	for _, jit := range []bool{false, true} {
+		if jit && !pcre2.JITSupported() {
+			continue
+		}
+
+		for _, pattern := range patterns {
+			re, err := pcre2.Compile(pattern)
+			if !assert.NoError(t, err, "Compile works") {
+				return
+			}
+			defer re.Free()
+
+			if jit {
+				if !assert.NoError(t, re.JIT(pcre2.JITComplete, pcre2.JITPartialHard), "JIT works") {
+					return
+				}
+			}
+
+			for _, subject := range subjects {
+				expected := re.FindAllStringSubmatchIndex(subject, -1)
+				for name, reader := range readers {
+					all, err := scanAll(t, re, reader(subject))
+					if !assert.NoError(t, err, "Scan works") {
+						return
+					}
+					if !assert.Equal(t, expected, all, "Scanner matches FindAll (%q against %q, %s reader, jit = %t)", pattern, subject, name, jit) {
+						return
+					}
+				}
+			}
+		}
+	}
+
+	// Matches are found in a stream much larger than the buffer
+	re, err := pcre2.Compile(`(\w+)=(\d+)`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	defer re.Free()
+
+	stream := strings.Repeat("key=42; 日本 k2=7 ", 10000)
+	s := re.NewScanner(iotest.HalfReader(strings.NewReader(stream)))
+	s.Buffer(make([]byte, 0, 16), 64)
+	count := 0
+	for s.Scan() {
+		count++
+		if !assert.Equal(t, stream[s.Index()[0]:s.Index()[1]], s.Text(), "Index refers to the stream") {
+			return
+		}
+		sub := s.Submatch()
+		if !assert.Len(t, sub, 3, "Submatch returns every group") {
+			return
+		}
+		if !assert.Equal(t, string(sub[1])+"="+string(sub[2]), s.Text(), "Submatch works") {
+			return
+		}
+	}
+	if !assert.NoError(t, s.Err(), "Scan works") {
+		return
+	}
+	if !assert.Equal(t, 20000, count, "every match is found") {
+		return
+	}
+
+	// A match that does not fit in the buffer is an error
+	s = re.NewScanner(strings.NewReader(strings.Repeat("x", 100) + "=1"))
+	s.Buffer(nil, 32)
+	if !assert.False(t, s.Scan(), "Scan fails") {
+		return
+	}
+	if !assert.Equal(t, bufio.ErrTooLong, s.Err(), "Err is ErrTooLong") {
+		return
+	}
+
+	// Invalid UTF-8 is handled according to the mode
+	subject := "ab\xffcd 日本\xfe"
+	all, err := scanAll(t, re, iotest.OneByteReader(strings.NewReader(subject)))
+	if !assert.True(t, errors.Is(err, pcre2.ErrInvalidUTF8String), "invalid UTF-8 is an error") {
+		return
+	}
+	if !assert.Empty(t, all, "no matches are found") {
+		return
+	}
+
+	for _, pattern := range []string{`\w+|.`, `x*`, `(?<=a)b`, `\w+$`, `.\z`} {
+		invalid, err := pcre2.Compile(pattern)
+		if !assert.NoError(t, err, "Compile works") {
+			return
+		}
+		defer invalid.Free()
+
+		for _, mode := range []pcre2.InvalidUTF8Mode{pcre2.InvalidUTF8Replace, pcre2.InvalidUTF8Skip} {
+			if !assert.NoError(t, invalid.SetInvalidUTF8Mode(mode), "SetInvalidUTF8Mode works") {
+				return
+			}
+			for _, subject := range []string{subject, "a\xffb ab\xff\xfe", "\xff", "ab\xe6\x97"} {
+				all, err := scanAll(t, invalid, iotest.OneByteReader(strings.NewReader(subject)))
+				if !assert.NoError(t, err, "Scan works") {
+					return
+				}
+				if !assert.Equal(t, invalid.FindAllStringSubmatchIndex(subject, -1), all, "Scanner matches FindAll (%q against %q, mode %d)", pattern, subject, mode) {
+					return
+				}
+			}
+		}
+	}
+
+	re.Free()
+	s = re.NewScanner(strings.NewReader(`a=1`))
+	if !assert.False(t, s.Scan(), "Scan fails after Free") {
+		return
+	}
+	if !assert.Equal(t, pcre2.ErrInvalidRegexp, s.Err(), "Err is ErrInvalidRegexp") {
+		return
+	}
+}
+
+// endlessReader returns the runes of s over and over again
+type endlessReader struct {
+	s string
+	i int
+}
+
+func (r *endlessReader) ReadRune() (rune, int, error) {
+	c, size := utf8.DecodeRuneInString(r.s[r.i:])
+	r.i = (r.i + size) % len(r.s)
+	return c, size, nil
+}
+
+func TestReader(t *testing.T) {
+	patterns := []string{`\d+`, `(\w+)@(\w+)`, `.`, `(a)|(b)`, `日本`, `x*`, `\x{fffd}+`}
+	subjects := []string{``, `abc 123`, `foo@bar baz@qux`, `日本語の日本`, "ab\xffcd\xfe\xfd@x"}
+
+	for _, pattern := range patterns {
+		re, err := pcre2.Compile(pattern)
+		if !assert.NoError(t, err, "Compile works") {
+			return
+		}
+		defer re.Free()
+		goRe := regexp.MustCompile(pattern)
+
+		for _, subject := range subjects {
+			if !assert.Equal(t, goRe.MatchReader(strings.NewReader(subject)), re.MatchReader(strings.NewReader(subject)), "MatchReader matches the regexp package (%q against %q)", pattern, subject) {
+				return
+			}
+			if !assert.Equal(t, goRe.FindReaderIndex(strings.NewReader(subject)), re.FindReaderIndex(strings.NewReader(subject)), "FindReaderIndex matches the regexp package (%q against %q)", pattern, subject) {
+				return
+			}
+			if !assert.Equal(t, goRe.FindReaderSubmatchIndex(strings.NewReader(subject)), re.FindReaderSubmatchIndex(strings.NewReader(subject)), "FindReaderSubmatchIndex matches the regexp package (%q against %q)", pattern, subject) {
+				return
+			}
+		}
+	}
+
+	// The input is read incrementally, so a match is found even if
+	// the input never ends
+	re, err := pcre2.Compile(`(\w+)@(\w+)`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	defer re.Free()
+
+	if !assert.Equal(t, []int{4, 11, 4, 7, 8, 11}, re.FindReaderSubmatchIndex(&endlessReader{s: "to: foo@bar, "}), "FindReaderSubmatchIndex works with an endless reader") {
+		return
+	}
+	if !assert.True(t, re.MatchReader(&endlessReader{s: "to: foo@bar, "}), "MatchReader works with an endless reader") {
+		return
+	}
+}
+
+func TestDFAMatch(t *testing.T) {
+	re, err := pcre2.Compile(`for|foreach|fo(r)?`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	defer re.Free()
+
+	matches, err := re.DFAMatchString(`x foreach y`)
+	if !assert.NoError(t, err, "DFAMatchString works") {
+		return
+	}
+	if !assert.Equal(t, [][]int{{2, 9}, {2, 5}, {2, 4}}, matches, "every match is returned, longest first") {
+		return
+	}
+
+	matches, err = re.DFAMatch([]byte(`x foreach y`), pcre2.DFAShortest)
+	if !assert.NoError(t, err, "DFAMatch works") {
+		return
+	}
+	if !assert.Equal(t, [][]int{{2, 4}}, matches, "DFAShortest returns the shortest match") {
+		return
+	}
+
+	matches, err = re.DFAMatchString(`nothing`)
+	if !assert.NoError(t, err, "DFAMatchString works") {
+		return
+	}
+	if !assert.Nil(t, matches, "no match") {
+		return
+	}
+
+	many, err := pcre2.CompileWithOptions(`a{1,40}`, pcre2.NoAutoPossess)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	defer many.Free()
+
+	matches, err = many.DFAMatchString(strings.Repeat("a", 50))
+	if !assert.NoError(t, err, "DFAMatchString works") {
+		return
+	}
+	if !assert.Len(t, matches, 40, "every match is returned") {
+		return
+	}
+	if !assert.Equal(t, []int{0, 40}, matches[0], "the longest match is first") {
+		return
+	}
+
+	for _, pattern := range []string{`(a)\1`, `a(*PRUNE)b`} {
+		unsupported, err := pcre2.Compile(pattern)
+		if !assert.NoError(t, err, "Compile works") {
+			return
+		}
+		defer unsupported.Free()
+
+		_, err = unsupported.DFAMatchString(`aab`)
+		if !assert.True(t, errors.Is(err, pcre2.ErrDFAUnsupported), "%s is not supported", pattern) {
+			return
+		}
+	}
+
+	date, err := pcre2.Compile(`^\d?\d(jan|feb|mar)\d\d$`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	defer date.Free()
+
+	ws := date.NewDFAWorkspace()
+	_, err = ws.Match([]byte(`23`), pcre2.DFARestart)
+	var merr pcre2.MatchError
+	if !assert.True(t, errors.As(err, &merr), "DFARestart needs a partial match") {
+		return
+	}
+
+	matches, err = ws.Match([]byte(`23ja`), pcre2.DFAPartialSoft)
+	if !assert.Equal(t, pcre2.ErrPartialMatch, err, "partial match") {
+		return
+	}
+	if !assert.Equal(t, [][]int{{0, 4}}, matches, "the partial match is returned") {
+		return
+	}
+	matches, err = ws.Match([]byte(`n05`), pcre2.DFARestart, pcre2.DFAPartialSoft)
+	if !assert.NoError(t, err, "restarted match works") {
+		return
+	}
+	if !assert.Equal(t, [][]int{{0, 3}}, matches, "offsets are relative to the new piece") {
+		return
+	}
+
+	fffd, err := pcre2.Compile(`a.b|a`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	defer fffd.Free()
+
+	if !assert.NoError(t, fffd.SetInvalidUTF8Mode(pcre2.InvalidUTF8Replace), "SetInvalidUTF8Mode works") {
+		return
+	}
+	matches, err = fffd.DFAMatchString("xa\xffb")
+	if !assert.NoError(t, err, "DFAMatchString works") {
+		return
+	}
+	if !assert.Equal(t, [][]int{{1, 4}, {1, 2}}, matches, "offsets are against the original subject") {
+		return
+	}
+	if !assert.NoError(t, fffd.SetInvalidUTF8Mode(pcre2.InvalidUTF8Skip), "SetInvalidUTF8Mode works") {
+		return
+	}
+	_, err = fffd.DFAMatchString("xa\xffb")
+	if !assert.True(t, errors.Is(err, pcre2.ErrDFAUnsupported), "InvalidUTF8Skip is not supported") {
+		return
+	}
+
+	re.Free()
+	_, err = re.DFAMatchString(`for`)
+	if !assert.Equal(t, pcre2.ErrInvalidRegexp, err, "DFAMatchString fails after Free") {
+		return
+	}
+}
+
+func TestLongest(t *testing.T) {
+	re, err := pcre2.Compile(`(fo)(r)?|foreach`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	defer re.Free()
+
+	if !assert.Equal(t, []int{2, 5}, re.FindStringIndex(`x foreach y`), "the first match is found by default") {
+		return
+	}
+	re.Longest()
+	if !assert.Equal(t, []int{2, 9, -1, -1, -1, -1}, re.FindStringSubmatchIndex(`x foreach y`), "the longest match is found") {
+		return
+	}
+	if !assert.Equal(t, [][]int{{0, 3, 0, 2, 2, 3}, {4, 11, -1, -1, -1, -1}}, re.FindAllStringSubmatchIndex(`for foreach`, -1), "the groups are those of the longest match") {
+		return
+	}
+	if !assert.Equal(t, `<for> <foreach>`, re.ReplaceAllString(`for foreach`, `<$0>`), "ReplaceAllString replaces the longest matches") {
+		return
+	}
+
+	// Back references are not supported by the DFA matcher
+	backref, err := pcre2.Compile(`(a|aa)\1`)
+	if !assert.NoError(t, err, "Compile works") {
+		return
+	}
+	defer backref.Free()
+
+	backref.Longest()
+	if !assert.Equal(t, []int{1, 5, 1, 3}, backref.FindStringSubmatchIndex(`baaaa`), "the longest match is found without the DFA matcher") {
+		return
+	}
+	if !assert.Equal(t, `b<aaaa>`, backref.ReplaceAllString(`baaaa`, `<${0}>`), "ReplaceAllString works") {
+		return
+	}
+}
+
+func TestCompilePOSIX(t *testing.T) {
+	tests := []struct {
+		pattern string
+		subject string
+	}{
+		{`(a|ab)(c|bcd)(d*)`, `abcd`},
+		{`(a*)*`, `a`},
+		{`(a*)+`, `b`},
+		{`(a|b)*`, `abab`},
+		{`X(.?){0,}Y`, `X1234567Y`},
+		{`X(.?){8,}Y`, `X1234567Y`},
+		{`(..)*(...)*`, `abcd`},
+		{`^a|b$`, "x\na\nb\n"},
+		{`[^a]+`, "bc\nd"},
+		{`a.c`, "a\nc abc"},
+		{`()|a`, `aaa`},
+		{`.*`, "a\xffb"},
+	}
+
+	for _, test := range tests {
+		gore := regexp.MustCompilePOSIX(test.pattern)
+		re, err := pcre2.CompilePOSIX(test.pattern)
+		if !assert.NoError(t, err, "CompilePOSIX works for %s", test.pattern) {
+			return
+		}
+		defer re.Free()
+
+		if !assert.Equal(t, test.pattern, re.String(), "String returns the expression") {
+			return
+		}
+		if !assert.Equal(t, gore.FindStringSubmatchIndex(test.subject), re.FindStringSubmatchIndex(test.subject), "%s on %q", test.pattern, test.subject) {
+			return
+		}
+		if !assert.Equal(t, gore.FindAllStringSubmatchIndex(test.subject, -1), re.FindAllStringSubmatchIndex(test.subject, -1), "%s on %q", test.pattern, test.subject) {
+			return
+		}
+		if !assert.Equal(t, gore.ReplaceAllString(test.subject, `<$1>`), re.ReplaceAllString(test.subject, `<$1>`), "%s on %q", test.pattern, test.subject) {
+			return
+		}
+	}
+
+	_, err := pcre2.CompilePOSIX(`a\d`)
+	var cerr pcre2.ErrCompile
+	if !assert.True(t, errors.As(err, &cerr), "Perl classes are not POSIX syntax") {
+		return
+	}
+	if !assert.Contains(t, cerr.Error(), "at offset 1", "the offset of the error is reported") {
+		return
+	}
+	if !assert.Panics(t, func() { pcre2.MustCompilePOSIX(`a(`) }, "MustCompilePOSIX panics") {
+		return
+	}
+
+	re := pcre2.MustCompilePOSIX(`(a|ab)(c|bcd)(d*)`)
+	defer re.Free()
+	data, err := pcre2.SerializeRegexps([]*pcre2.Regexp{re})
+	if !assert.NoError(t, err, "SerializeRegexps works") {
+		return
+	}
+	list, err := pcre2.DeserializeRegexps(data)
+	if !assert.NoError(t, err, "DeserializeRegexps works") {
+		return
+	}
+	defer list[0].Free()
+	if !assert.Equal(t, []int{0, 4, 0, 1, 1, 4, 4, 4}, list[0].FindStringSubmatchIndex(`abcd`), "leftmost-longest matching is restored") {
+		return
+	}
+}
+
+// TestCompilePOSIXCorpus compares CompilePOSIX with the regexp package
+// on the POSIX test corpus that comes with Go
+func TestCompilePOSIXCorpus(t *testing.T) {
+	dir := filepath.Join(runtime.GOROOT(), "src", "regexp", "testdata")
+	notab := regexp.MustCompilePOSIX(`[^\t]+`)
+	for _, name := range []string{"basic.dat", "nullsubexpr.dat", "repetition.dat"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Skipf("test corpus not found: %s", err)
+		}
+
+		lastPattern := ""
+		for lineno, line := range strings.Split(string(data), "\n") {
+			if line == "" || line[0] == '#' {
+				continue
+			}
+			field := notab.FindAllString(line, -1)
+			if len(field) < 4 {
+				continue
+			}
+			flag := field[0]
+			switch flag[0] {
+			case '?', '&', '|', ';', '{', '}':
+				flag = flag[1:]
+			case ':':
+				i := strings.IndexByte(flag[1:], ':')
+				if i < 0 {
+					continue
+				}
+				flag = flag[i+2:]
+			}
+			for i, f := range field {
+				if f == "NULL" {
+					field[i] = ""
+				}
+			}
+			if strings.Contains(flag, "$") {
+				field[1], _ = strconv.Unquote(`"` + field[1] + `"`)
+				field[2], _ = strconv.Unquote(`"` + field[2] + `"`)
+			}
+			if field[1] == "SAME" {
+				field[1] = lastPattern
+			}
+			lastPattern = field[1]
+			if !strings.Contains(flag, "E") || strings.Contains(flag, "i") || field[2] == "NIL" {
+				continue
+			}
+
+			pattern, subject := field[1], field[2]
+			gore, goerr := regexp.CompilePOSIX(pattern)
+			re, err := pcre2.CompilePOSIX(pattern)
+			if !assert.Equal(t, goerr == nil, err == nil, "%s:%d: %s compiles in both or neither", name, lineno+1, pattern) {
+				return
+			}
+			if err != nil {
+				continue
+			}
+			if !assert.Equal(t, gore.FindStringSubmatchIndex(subject), re.FindStringSubmatchIndex(subject), "%s:%d: %s on %q", name, lineno+1, pattern, subject) {
+				return
+			}
+			if !assert.Equal(t, gore.FindAllStringSubmatchIndex(subject, -1), re.FindAllStringSubmatchIndex(subject, -1), "%s:%d: %s on %q", name, lineno+1, pattern, subject) {
+				return
+			}
+			re.Free()
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package pcre2
+
+import (
+	"errors"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+)
+
+// CompilePOSIX is like Compile, but restricts the regular expression
+// to POSIX ERE (egrep) syntax, and changes the match semantics to
+// leftmost-longest, just like CompilePOSIX of the regexp package.
+//
+// The expression is parsed by the regexp/syntax package, so it is
+// accepted and interpreted exactly as the regexp package would, and
+// then converted to an equivalent PCRE2 pattern. As in the regexp
+// package, ^ and $ match at the start and end of each line, and
+// negated character classes do not match newlines. Subjects that are
+// not valid UTF-8 are matched in InvalidUTF8Replace mode, in which each
+// invalid byte matches U+FFFD, as in the regexp package. String returns
+// expr, not the converted pattern.
+func CompilePOSIX(expr string) (*Regexp, error) {
+	re, err := syntax.Parse(expr, syntax.POSIX)
+	if err != nil {
+		return nil, posixError(expr, err)
+	}
+
+	w := posixWriter{}
+	w.write(re)
+	code, err := compile(w.String(), UTF)
+	if err != nil {
+		return nil, err
+	}
+	r := newRegexp(expr, code)
+	r.posix = true
+	r.invalidUTF8 = InvalidUTF8Replace
+	r.Longest()
+	return r, nil
+}
+
+// MustCompilePOSIX is like CompilePOSIX but panics if the expression
+// cannot be parsed.
+func MustCompilePOSIX(expr string) *Regexp {
+	r, err := CompilePOSIX(expr)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// posixError converts an error from the regexp/syntax package to an
+// ErrCompile. The offset is that of the part of the expression that
+// the error refers to.
+func posixError(expr string, err error) error {
+	var serr *syntax.Error
+	if !errors.As(err, &serr) {
+		return ErrCompile{pattern: expr, message: err.Error()}
+	}
+
+	offset := strings.Index(expr, serr.Expr)
+	if offset < 0 {
+		offset = 0
+	}
+	return ErrCompile{
+		pattern: expr,
+		offset:  offset,
+		message: serr.Code.String() + ": `" + serr.Expr + "`",
+	}
+}
+
+// pcre2Pattern returns the pattern that is given to PCRE2 when the
+// Regexp is compiled again
+func (r *Regexp) pcre2Pattern() string {
+	if !r.posix {
+		return r.pattern
+	}
+	pattern, _ := r.posixPattern(false)
+	return pattern
+}
+
+// posixPattern converts the expression of a Regexp that was created by
+// CompilePOSIX to a PCRE2 pattern. If guard is true, loop guards are
+// added as described in posixWriter, and the group of the expression
+// that each group in the pattern corresponds to is returned as well.
+func (r *Regexp) posixPattern(guard bool) (string, []int) {
+	// The expression was parsed successfully when the Regexp was
+	// created, but may have been deserialized by another program
+	re, err := syntax.Parse(r.pattern, syntax.POSIX)
+	if err != nil {
+		return r.pattern, nil
+	}
+
+	w := posixWriter{guard: guard, groups: []int{0}}
+	w.write(re)
+	return w.String(), w.groups
+}
+
+// posixWriter converts a parsed regular expression to a PCRE2 pattern
+// that matches the same strings, with the same groups. Anchors and .
+// are written as lookarounds and classes that only look for \n, so that
+// they do not depend on the newline convention of PCRE2. All other
+// characters that are not letters or digits are written as escapes.
+//
+// PCRE2 ends a loop once an iteration matches the empty string, while
+// the regexp package treats an empty iteration other than the first as
+// a failure. This makes no difference to whether there is a match, but
+// may to the groups that are set. If guard is true, each loop whose
+// body can match the empty string is written as ()(?:(body)(?C{i l}))*,
+// where l and i are the numbers of the two added groups, and the
+// callout fails an empty iteration that does not start where the loop
+// started. The added groups are recorded as -1 in groups. As x{n,} is
+// written as n-1 copies of x followed by a loop, a group may appear more
+// than once, and then it is the last one that was set that counts.
+// Names are left out, as they would not be unique.
+type posixWriter struct {
+	strings.Builder
+	guard  bool
+	groups []int
+}
+
+func (w *posixWriter) write(re *syntax.Regexp) {
+	switch re.Op {
+	case syntax.OpNoMatch:
+		w.WriteString(`(*FAIL)`)
+	case syntax.OpEmptyMatch:
+		w.WriteString(`(?:)`)
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			w.WriteString(`(?i:`)
+			defer w.WriteString(`)`)
+		}
+		for _, c := range re.Rune {
+			w.writeRune(c)
+		}
+	case syntax.OpCharClass:
+		w.writeClass(re.Rune)
+	case syntax.OpAnyCharNotNL:
+		w.WriteString(`[^\n]`)
+	case syntax.OpAnyChar:
+		w.WriteString(`(?s:.)`)
+	case syntax.OpBeginLine:
+		w.WriteString(`(?<![^\n])`)
+	case syntax.OpEndLine:
+		w.WriteString(`(?![^\n])`)
+	case syntax.OpBeginText:
+		w.WriteString(`\A`)
+	case syntax.OpEndText:
+		w.WriteString(`\z`)
+	case syntax.OpWordBoundary:
+		w.WriteString(`\b`)
+	case syntax.OpNoWordBoundary:
+		w.WriteString(`\B`)
+	case syntax.OpCapture:
+		w.groups = append(w.groups, re.Cap)
+		if re.Name != "" && !w.guard {
+			w.WriteString(`(?<` + re.Name + `>`)
+		} else {
+			w.WriteString(`(`)
+		}
+		w.write(re.Sub[0])
+		w.WriteString(`)`)
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		if w.guard && re.Op != syntax.OpQuest && (re.Op != syntax.OpRepeat || re.Max < 0) && nullable(re.Sub[0]) {
+			// Like the regexp package, write x{n,} as n-1 copies of x
+			// followed by x+, so that only the loop is guarded
+			if re.Op == syntax.OpRepeat && re.Min > 1 {
+				for i := 1; i < re.Min; i++ {
+					w.writeAtom(re.Sub[0])
+				}
+				re = &syntax.Regexp{Op: syntax.OpPlus, Flags: re.Flags, Sub: re.Sub}
+			}
+			l := w.addGroup()
+			w.WriteString(`()(?:(`)
+			i := w.addGroup()
+			w.write(re.Sub[0])
+			w.WriteString(`)(?C{` + strconv.Itoa(i) + ` ` + strconv.Itoa(l) + `}))`)
+		} else {
+			w.writeAtom(re.Sub[0])
+		}
+		switch re.Op {
+		case syntax.OpStar:
+			w.WriteString(`*`)
+		case syntax.OpPlus:
+			w.WriteString(`+`)
+		case syntax.OpQuest:
+			w.WriteString(`?`)
+		default:
+			w.WriteString(`{` + strconv.Itoa(re.Min))
+			if re.Max != re.Min {
+				w.WriteString(`,`)
+				if re.Max >= 0 {
+					w.WriteString(strconv.Itoa(re.Max))
+				}
+			}
+			w.WriteString(`}`)
+		}
+		if re.Flags&syntax.NonGreedy != 0 {
+			w.WriteString(`?`)
+		}
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if sub.Op == syntax.OpAlternate {
+				w.writeAtom(sub)
+				continue
+			}
+			w.write(sub)
+		}
+	case syntax.OpAlternate:
+		for i, sub := range re.Sub {
+			if i > 0 {
+				w.WriteString(`|`)
+			}
+			w.write(sub)
+		}
+	}
+}
+
+// addGroup records a group that is added by a loop guard, and returns
+// its number
+func (w *posixWriter) addGroup() int {
+	w.groups = append(w.groups, -1)
+	return len(w.groups) - 1
+}
+
+// writeAtom writes re so that it can be followed by a quantifier
+func (w *posixWriter) writeAtom(re *syntax.Regexp) {
+	switch {
+	case re.Op == syntax.OpCapture, re.Op == syntax.OpCharClass,
+		re.Op == syntax.OpAnyChar, re.Op == syntax.OpAnyCharNotNL,
+		re.Op == syntax.OpLiteral && len(re.Rune) == 1:
+		w.write(re)
+	default:
+		w.WriteString(`(?:`)
+		w.write(re)
+		w.WriteString(`)`)
+	}
+}
+
+// writeClass writes a character class made of the given pairs of
+// ranges. Surrogates cannot appear in UTF-8, and PCRE2 does not
+// accept them in patterns, so they are left out.
+func (w *posixWriter) writeClass(ranges []rune) {
+	var class posixWriter
+	for i := 0; i+1 < len(ranges); i += 2 {
+		lo, hi := ranges[i], ranges[i+1]
+		if lo >= 0xd800 && lo <= 0xdfff {
+			lo = 0xe000
+		}
+		if hi >= 0xd800 && hi <= 0xdfff {
+			hi = 0xd7ff
+		}
+		if lo > hi {
+			continue
+		}
+		class.writeRune(lo)
+		if hi > lo {
+			class.WriteString(`-`)
+			class.writeRune(hi)
+		}
+	}
+
+	if class.Len() == 0 {
+		w.WriteString(`(*FAIL)`)
+		return
+	}
+	w.WriteString(`[` + class.String() + `]`)
+}
+
+func (w *posixWriter) writeRune(c rune) {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		w.WriteRune(c)
+	default:
+		w.WriteString(`\x{` + strconv.FormatInt(int64(c), 16) + `}`)
+	}
+}
+
+// nullable returns true if re can match the empty string
+func nullable(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine,
+		syntax.OpBeginText, syntax.OpEndText,
+		syntax.OpWordBoundary, syntax.OpNoWordBoundary,
+		syntax.OpStar, syntax.OpQuest:
+		return true
+	case syntax.OpLiteral:
+		return len(re.Rune) == 0
+	case syntax.OpCapture, syntax.OpPlus:
+		return nullable(re.Sub[0])
+	case syntax.OpRepeat:
+		return re.Min == 0 || nullable(re.Sub[0])
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if !nullable(sub) {
+				return false
+			}
+		}
+		return true
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			if nullable(sub) {
+				return true
+			}
+		}
+	}
+	return false
+}
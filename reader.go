@@ -0,0 +1,111 @@
+package pcre2
+
+import (
+	"io"
+	"math"
+	"sort"
+	"unicode/utf8"
+)
+
+// runeShift records that the runes read up to offset end in the UTF-8
+// stream were delta bytes longer, in total, than in the input
+type runeShift struct {
+	end   int64
+	delta int64
+}
+
+// runeBytesReader turns an io.RuneReader into an io.Reader of UTF-8.
+// Invalid input, which ReadRune returns as utf8.RuneError with a size
+// of 1, is encoded as U+FFFD, which is longer. The difference is kept,
+// so that offsets can be converted back with inputOffset.
+type runeBytesReader struct {
+	rd      io.RuneReader
+	offset  int64 // number of bytes returned so far
+	delta   int64
+	shifts  []runeShift
+	pending []byte // the rest of a rune that did not fit into p
+}
+
+func (r *runeBytesReader) Read(p []byte) (int, error) {
+	var buf [utf8.UTFMax]byte
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	for n < len(p) {
+		c, size, err := r.rd.ReadRune()
+		if err != nil {
+			if n > 0 && err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+
+		l := utf8.EncodeRune(buf[:], c)
+		r.offset += int64(l)
+		if l != size {
+			r.delta += int64(l - size)
+			r.shifts = append(r.shifts, runeShift{end: r.offset, delta: r.delta})
+		}
+
+		copied := copy(p[n:], buf[:l])
+		r.pending = append(r.pending[:0], buf[copied:l]...)
+		n += copied
+	}
+	return n, nil
+}
+
+// inputOffset converts an offset in the UTF-8 stream to an offset in
+// the input, as counted by the sizes returned by ReadRune
+func (r *runeBytesReader) inputOffset(i int64) int64 {
+	n := sort.Search(len(r.shifts), func(k int) bool { return r.shifts[k].end > i })
+	if n == 0 {
+		return i
+	}
+	return i - r.shifts[n-1].delta
+}
+
+// findReader returns the offsets of the leftmost match in the input
+// read from rd, along with those of its first howmany-1 groups
+func (r *Regexp) findReader(rd io.RuneReader, howmany int) []int {
+	in := &runeBytesReader{rd: rd}
+	s := r.NewScanner(in)
+	s.Buffer(nil, math.MaxInt)
+	if !s.Scan() {
+		return nil
+	}
+
+	index := s.SubmatchIndex()[:2*howmany]
+	out := make([]int, len(index))
+	for i, v := range index {
+		if v < 0 {
+			out[i] = -1
+			continue
+		}
+		out[i] = int(in.inputOffset(v))
+	}
+	return out
+}
+
+// MatchReader reports whether the text returned by the RuneReader
+// contains any match of the Regexp. The input is read incrementally,
+// and reading stops soon after a match has been found.
+func (r *Regexp) MatchReader(rd io.RuneReader) bool {
+	return r.findReader(rd, 1) != nil
+}
+
+// FindReaderIndex returns a two-element slice of integers defining the
+// location of the leftmost match of the Regexp in text read from the
+// RuneReader. The match text was found in the input stream at byte
+// offset loc[0] through loc[1]-1. A return value of nil indicates no
+// match.
+func (r *Regexp) FindReaderIndex(rd io.RuneReader) []int {
+	return r.findReader(rd, 1)
+}
+
+// FindReaderSubmatchIndex returns a slice holding the index pairs
+// identifying the leftmost match of the Regexp in text read by the
+// RuneReader, and the matches, if any, of its subexpressions, as
+// defined by FindSubmatchIndex. A return value of nil indicates no
+// match.
+func (r *Regexp) FindReaderSubmatchIndex(rd io.RuneReader) []int {
+	return r.findReader(rd, r.NumSubexp()+1)
+}
@@ -0,0 +1,327 @@
+package pcre2
+
+/*
+#define PCRE2_CODE_UNIT_WIDTH 8
+#include <pcre2.h>
+
+#ifndef PCRE2_SUBSTITUTE_LITERAL
+#define PCRE2_SUBSTITUTE_LITERAL 0x00008000u
+#endif
+*/
+import "C"
+import (
+	"bytes"
+	"context"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// Options for Regexp.Substitute. These may be OR'ed together
+const (
+	// SubstituteGlobal replaces every match, instead of just the first
+	SubstituteGlobal SubstituteOptions = C.PCRE2_SUBSTITUTE_GLOBAL
+	// SubstituteExtended enables the extended replacement syntax, which
+	// supports escapes such as \n, case conversion with \U, \L, \u
+	// and \l, and conditionals such as ${1:+yes:no}
+	SubstituteExtended SubstituteOptions = C.PCRE2_SUBSTITUTE_EXTENDED
+	// SubstituteUnknownUnset treats references to groups that do not
+	// exist as references to unset groups
+	SubstituteUnknownUnset SubstituteOptions = C.PCRE2_SUBSTITUTE_UNKNOWN_UNSET
+	// SubstituteUnsetEmpty causes references to unset groups to be
+	// replaced by an empty string, instead of being an error
+	SubstituteUnsetEmpty SubstituteOptions = C.PCRE2_SUBSTITUTE_UNSET_EMPTY
+	// SubstituteLiteral inserts the replacement string as is, without
+	// interpreting any special characters. Requires PCRE2 10.38
+	SubstituteLiteral SubstituteOptions = C.PCRE2_SUBSTITUTE_LITERAL
+)
+
+// substitute calls pcre2_substitute, and returns the resulting string
+// along with the number of replacements that were made. The output
+// buffer is grown as required.
+func (r *Regexp) substitute(subject []byte, replacement []byte, options C.uint32_t) ([]byte, int, error) {
+	rptr, err := r.acquire()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer r.release()
+
+	matchContext, release := r.newMatchContext(r.effectiveLimits(nil), r.newCalloutState(context.Background()))
+	defer release()
+
+	subject, _ = r.prepareSubject(subject)
+
+	// Start with a buffer large enough for most replacements. If it
+	// is not, PCRE2 tells us the required length and we try again
+	outlen := C.PCRE2_SIZE(len(subject) + len(replacement) + 1)
+	for {
+		out := make([]byte, int(outlen))
+		rc := C.pcre2_substitute(
+			rptr,
+			subjectPtr(subject),
+			C.PCRE2_SIZE(len(subject)),
+			0,
+			options|C.PCRE2_SUBSTITUTE_OVERFLOW_LENGTH,
+			nil,
+			matchContext,
+			subjectPtr(replacement),
+			C.PCRE2_SIZE(len(replacement)),
+			(*C.PCRE2_UCHAR)(unsafe.Pointer(&out[0])),
+			&outlen,
+		)
+		if rc == C.PCRE2_ERROR_NOMEMORY {
+			continue
+		}
+		switch rc {
+		case C.PCRE2_ERROR_BADREPLACEMENT, C.PCRE2_ERROR_BADREPESCAPE, C.PCRE2_ERROR_REPMISSINGBRACE,
+			C.PCRE2_ERROR_BADSUBSTITUTION, C.PCRE2_ERROR_NOSUBSTRING, C.PCRE2_ERROR_UNSET:
+			// PCRE2 reports where in the replacement the error was found
+			offset := int(outlen)
+			if offset > len(replacement) {
+				offset = len(replacement)
+			}
+			return nil, 0, ErrSubstitute{
+				code:    int(rc),
+				offset:  offset,
+				message: errorMessage(rc),
+			}
+		}
+		if rc < 0 {
+			return nil, 0, matchError(int(rc))
+		}
+		return out[:outlen], int(rc), nil
+	}
+}
+
+// Substitute replaces matches of the Regexp in subject using PCRE2's
+// own replacement syntax, which supports references such as $1, ${1}
+// and ${name}. More features, such as case conversion, are available
+// with SubstituteExtended. Unless SubstituteGlobal is given, only the
+// first match is replaced.
+//
+// Substitute returns the resulting string along with the number of
+// replacements that were made. If the replacement string is invalid,
+// the error is an ErrSubstitute that reports where the problem is.
+func (r *Regexp) Substitute(subject, replacement string, opts SubstituteOptions) (string, int, error) {
+	if !utf8.ValidString(replacement) {
+		return "", 0, ErrInvalidUTF8String
+	}
+
+	out, count, err := r.substitute(stringBytes(subject), stringBytes(replacement), C.uint32_t(opts))
+	if err != nil {
+		return "", 0, err
+	}
+	return string(out), count, nil
+}
+
+// extractTemplateName parses a $name or ${name} reference at the start
+// of template, which follows the '$', as the Expand method of the
+// regexp package does. num is the group number, or -1 if name is not
+// a number. ok is false if there is no valid reference.
+func extractTemplateName(template string) (name string, num int, rest string, ok bool) {
+	if template == "" {
+		return
+	}
+	brace := false
+	if template[0] == '{' {
+		brace = true
+		template = template[1:]
+	}
+	i := 0
+	for i < len(template) {
+		c, size := utf8.DecodeRuneInString(template[i:])
+		if c != '_' && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			break
+		}
+		i += size
+	}
+	if i == 0 {
+		return
+	}
+	name = template[:i]
+	if brace {
+		if i >= len(template) || template[i] != '}' {
+			return
+		}
+		i++
+	}
+
+	num = 0
+	for j := 0; j < len(name); j++ {
+		if name[j] < '0' || name[j] > '9' || num >= 1e8 {
+			num = -1
+			break
+		}
+		num = num*10 + int(name[j]-'0')
+	}
+	// Leading zeros make it a name, which never refers to a group
+	if name[0] == '0' && len(name) > 1 {
+		num = -1
+	}
+	return name, num, template[i:], true
+}
+
+// expandTemplate appends template to dst, with the references to
+// groups replaced by their text in src, as the Expand method of the
+// regexp package does. A name refers to the first group of that name
+// that is set. References to groups that do not exist or are unset
+// expand to the empty string, and a '$' that does not start a
+// reference is kept as is.
+func expandTemplate(dst []byte, template string, src []byte, match []int, names []string) []byte {
+	for {
+		i := strings.IndexByte(template, '$')
+		if i < 0 {
+			return append(dst, template...)
+		}
+		dst = append(dst, template[:i]...)
+		template = template[i+1:]
+		if template != "" && template[0] == '$' {
+			dst = append(dst, '$')
+			template = template[1:]
+			continue
+		}
+
+		name, num, rest, ok := extractTemplateName(template)
+		if !ok {
+			dst = append(dst, '$')
+			continue
+		}
+		template = rest
+
+		if num >= 0 {
+			if 2*num+1 < len(match) && match[2*num] >= 0 {
+				dst = append(dst, src[match[2*num]:match[2*num+1]]...)
+			}
+			continue
+		}
+		for i, n := range names {
+			if n == name && 2*i+1 < len(match) && match[2*i] >= 0 {
+				dst = append(dst, src[match[2*i]:match[2*i+1]]...)
+				break
+			}
+		}
+	}
+}
+
+// replaceAll replaces the matches that FindAll finds with the output
+// of repl, which is given the offsets of each match, and of its groups
+// if submatches is true
+func (r *Regexp) replaceAll(src []byte, submatches bool, repl func(dst []byte, match []int) []byte) ([]byte, bool) {
+	find := r.findAllIndex
+	if submatches {
+		find = r.findAllSubmatchIndex
+	}
+	matches, err := find(context.Background(), src, -1, nil)
+	if err != nil {
+		return nil, false
+	}
+
+	var out []byte
+	last := 0
+	for _, match := range matches {
+		out = append(out, src[last:match[0]]...)
+		out = repl(out, match)
+		last = match[1]
+	}
+	out = append(out, src[last:]...)
+	if len(out) == 0 {
+		// Like the regexp package, return nil instead of an empty slice
+		return nil, true
+	}
+	return out, true
+}
+
+// ReplaceAll returns a copy of src, replacing matches of the Regexp
+// with the replacement text repl. Inside repl, $ signs are interpreted
+// as in Expand of the regexp package, so for instance $1 represents
+// the text of the first submatch.
+//
+// The matches are those that FindAll finds, so the result is the same
+// as with the regexp package. Substitute, which uses pcre2_substitute,
+// also replaces an empty match right after a previous match, and
+// copies U+FFFD into the result for invalid bytes in InvalidUTF8Replace
+// mode.
+func (r *Regexp) ReplaceAll(src, repl []byte) []byte {
+	template := string(repl)
+	names := r.SubexpNames()
+	out, ok := r.replaceAll(src, true, func(dst []byte, match []int) []byte {
+		return expandTemplate(dst, template, src, match, names)
+	})
+	if !ok {
+		return src
+	}
+	return out
+}
+
+// ReplaceAllString returns a copy of src, replacing matches of the
+// Regexp with the replacement string repl. Inside repl, $ signs are
+// interpreted as in ReplaceAll.
+func (r *Regexp) ReplaceAllString(src, repl string) string {
+	b := stringBytes(src)
+	names := r.SubexpNames()
+	out, ok := r.replaceAll(b, true, func(dst []byte, match []int) []byte {
+		return expandTemplate(dst, repl, b, match, names)
+	})
+	if !ok {
+		return src
+	}
+	return string(out)
+}
+
+// ReplaceAllLiteral returns a copy of src, replacing matches of the
+// Regexp with the replacement bytes repl. The replacement repl is
+// substituted directly, without using Expand.
+func (r *Regexp) ReplaceAllLiteral(src, repl []byte) []byte {
+	out, ok := r.replaceAll(src, false, func(dst []byte, _ []int) []byte {
+		return append(dst, repl...)
+	})
+	if !ok {
+		return src
+	}
+	return out
+}
+
+// ReplaceAllLiteralString returns a copy of src, replacing matches of
+// the Regexp with the replacement string repl. The replacement repl is
+// substituted directly, without using Expand.
+func (r *Regexp) ReplaceAllLiteralString(src, repl string) string {
+	out, ok := r.replaceAll(stringBytes(src), false, func(dst []byte, _ []int) []byte {
+		return append(dst, repl...)
+	})
+	if !ok {
+		return src
+	}
+	return string(out)
+}
+
+// ReplaceAllFunc returns a copy of src in which all matches of the
+// Regexp have been replaced by the return value of function repl
+// applied to the matched byte slice. The replacement returned by repl
+// is substituted directly, without using Expand.
+func (r *Regexp) ReplaceAllFunc(src []byte, repl func([]byte) []byte) []byte {
+	var buf []byte
+	last := 0
+	for _, is := range r.FindAllIndex(src, -1) {
+		buf = append(buf, src[last:is[0]]...)
+		buf = append(buf, repl(src[is[0]:is[1]])...)
+		last = is[1]
+	}
+	return append(buf, src[last:]...)
+}
+
+// ReplaceAllStringFunc returns a copy of src in which all matches of
+// the Regexp have been replaced by the return value of function repl
+// applied to the matched substring. The replacement returned by repl
+// is substituted directly, without using Expand.
+func (r *Regexp) ReplaceAllStringFunc(src string, repl func(string) string) string {
+	var buf bytes.Buffer
+	last := 0
+	for _, is := range r.FindAllStringIndex(src, -1) {
+		buf.WriteString(src[last:is[0]])
+		buf.WriteString(repl(src[is[0]:is[1]]))
+		last = is[1]
+	}
+	buf.WriteString(src[last:])
+	return buf.String()
+}
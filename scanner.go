@@ -0,0 +1,354 @@
+package pcre2
+
+/*
+#define PCRE2_CODE_UNIT_WIDTH 8
+#include <pcre2.h>
+*/
+import "C"
+import (
+	"bufio"
+	"context"
+	"io"
+)
+
+// scannerReadSize is the initial size of the buffer of a Scanner
+const scannerReadSize = 4096
+
+// NewScanner returns a Scanner that reads from rd, and finds successive
+// non-overlapping matches of the Regexp in the stream, just as FindAll
+// would if the whole stream was a single []byte.
+//
+// The Scanner keeps the part of the stream that a match may still need
+// in memory, and discards the rest. Until the end of the stream is
+// reached, matching is done with PartialHard, so that a match that may
+// continue past the data that has been read so far is retried once more
+// data is available. JIT compiling the pattern with JITPartialHard
+// speeds this up. \G matches where FindAll would start its search, so
+// if it is used anywhere but at the start of the pattern, everything
+// after the previous match is kept.
+func (r *Regexp) NewScanner(rd io.Reader) *Scanner {
+	return &Scanner{
+		regexp:  r,
+		reader:  rd,
+		prevEnd: -1,
+		maxSize: bufio.MaxScanTokenSize,
+	}
+}
+
+// Buffer sets the initial buffer to use when reading from the stream,
+// and the maximum size of the buffer, like the Buffer method of
+// bufio.Scanner. The buffer has to hold a whole match, along with the
+// characters that lookbehinds may inspect before it. If a match does
+// not fit, Scan fails with bufio.ErrTooLong.
+//
+// By default, the maximum size is bufio.MaxScanTokenSize. Buffer must
+// be called before the first call to Scan.
+func (s *Scanner) Buffer(buf []byte, max int) {
+	s.buf = buf[:0]
+	s.maxSize = max
+}
+
+// Scan advances the Scanner to the next match, which is then available
+// through Bytes, Text, Index, Submatch and SubmatchIndex. It returns
+// false when there are no more matches, or when an error occurred.
+// After Scan returns false, Err returns the error, if any.
+func (s *Scanner) Scan() bool {
+	s.index = s.index[:0]
+	if s.err != nil || s.done {
+		return false
+	}
+
+	r := s.regexp
+	rptr, err := r.acquire()
+	if err != nil {
+		s.err = err
+		return false
+	}
+	defer r.release()
+
+	md := r.getMatchData()
+	defer r.putMatchData(md)
+
+	utf := hasOption(rptr, UTF)
+	// PCRE2 also reports patterns that start with \G as anchored
+	anchored := hasOption(rptr, Anchored)
+	fixedStart := !anchored && usesStartOffset(r.pattern)
+	for {
+		// Until the end of the stream, the last character that was
+		// read may be incomplete, and a match that reaches the end of
+		// the data may continue past it
+		end := len(s.buf)
+		options := 0
+		if !s.eof {
+			if utf {
+				end = completeLen(s.buf)
+			}
+			options |= C.PCRE2_PARTIAL_HARD
+		}
+		if s.base > 0 {
+			options |= C.PCRE2_NOTBOL
+		}
+
+		start := int(s.pos - s.base)
+		if s.skip {
+			// Like FindAll, move on by one character after an empty
+			// match. A CR at the end of the data may be followed by LF
+			switch {
+			case start < end && (s.eof || end-start > 1 || s.buf[start] != '\r'):
+				s.pos += int64(charLen(rptr, s.buf[start:end]))
+				s.skip = false
+				continue
+			case start >= end && s.eof:
+				s.done = true
+				return false
+			}
+			if !s.fill(rptr) {
+				return false
+			}
+			continue
+		}
+		if start > end {
+			if !s.fill(rptr) {
+				return false
+			}
+			continue
+		}
+
+		// In InvalidUTF8Skip mode, an invalid byte is a barrier that
+		// no match can cross, so matches that start before it do not
+		// depend on the data that follows. PCRE2 reports the end of
+		// each valid segment as a partial match, so only match up to
+		// there without partial matching
+		barrier := -1
+		if r.invalidUTF8 == InvalidUTF8Skip {
+			if i := invalidIndex(s.buf[start:end]); i >= 0 {
+				barrier = start + i
+				options &^= C.PCRE2_PARTIAL_HARD
+			}
+		}
+
+		subject, repl := r.prepareSubject(s.buf[:end])
+		rc, err := r.matchSubjectContext(context.Background(), rptr, subject, repl.preparedOffset(start), options, md.ptr, nil)
+		if err != nil {
+			s.err = err
+			return false
+		}
+
+		if rc == C.PCRE2_ERROR_PARTIAL {
+			// No match can start before the partial match, so there
+			// is no need to try those positions again. That does not
+			// hold if \G may refer to the start of the search
+			if partialStart := s.base + int64(repl.originalOffset(int(pcre2GetOvectorPointer(md.ptr, 1)[0]))); partialStart > s.pos && !fixedStart {
+				s.pos = partialStart
+			}
+			if !s.fill(rptr) {
+				return false
+			}
+			continue
+		}
+
+		if rc < 0 {
+			// Like FindAll, stop at the first position where an
+			// anchored pattern does not match. PCRE2 only reports a
+			// partial match once it has seen a character, so that
+			// needs some data
+			if anchored && (s.eof || start < end) {
+				s.done = true
+				return false
+			}
+			if anchored || fixedStart {
+				// Starting the search anywhere else would change
+				// where \G matches, so retry once more data is read
+				if s.eof {
+					s.done = true
+					return false
+				}
+				if !s.fill(rptr) {
+					return false
+				}
+				continue
+			}
+			if barrier >= 0 {
+				s.pos = s.base + int64(barrier) + 1
+				continue
+			}
+			// No match can start anywhere in the data that has been
+			// read so far
+			if s.eof {
+				s.done = true
+				return false
+			}
+			s.pos = s.base + int64(end)
+			if !s.fill(rptr) {
+				return false
+			}
+			continue
+		}
+
+		howmany := r.subexps + 1
+		index := ovectorToIndex(pcre2GetOvectorPointer(md.ptr, howmany), howmany, repl)
+		matchStart := s.base + int64(index[0])
+		matchEnd := s.base + int64(index[1])
+		if barrier >= 0 && index[0] > barrier {
+			// The match was found without partial matching, so it
+			// may continue past the data that has been read so far
+			switch {
+			case !fixedStart:
+				s.pos = s.base + int64(barrier) + 1
+				continue
+			case !s.eof:
+				if !s.fill(rptr) {
+					return false
+				}
+				continue
+			}
+		}
+
+		adjacent := matchStart == matchEnd && matchStart == s.prevEnd
+		s.prevEnd = matchEnd
+		s.pos = matchEnd
+		s.skip = matchStart == matchEnd
+		if adjacent {
+			// Like FindAll, skip an empty match right after the
+			// previous match
+			continue
+		}
+
+		s.index = s.index[:0]
+		for _, v := range index {
+			if v < 0 {
+				s.index = append(s.index, -1)
+				continue
+			}
+			s.index = append(s.index, s.base+int64(v))
+		}
+		return true
+	}
+}
+
+// usesStartOffset reports whether pattern may contain \G, which only
+// matches at the offset where the search started. \Q...\E is not
+// taken into account, so it may report patterns that do not.
+func usesStartOffset(pattern string) bool {
+	for i := 0; i < len(pattern)-1; i++ {
+		if pattern[i] == '\\' {
+			if pattern[i+1] == 'G' {
+				return true
+			}
+			i++
+		}
+	}
+	return false
+}
+
+// fill discards the data that is no longer needed, and reads more
+// data from the stream. It returns false if an error occurred.
+func (s *Scanner) fill(rptr *C.pcre2_code) bool {
+	// Keep the characters that lookbehinds may inspect, and one more
+	// for \b and ^ in multiline mode. If that is the LF of a CRLF, the
+	// CR is needed as well
+	start := int(s.pos - s.base)
+	if start > len(s.buf) {
+		start = len(s.buf)
+	}
+	keep := charsBefore(rptr, s.buf, start, int(infoUint32(rptr, C.PCRE2_INFO_MAXLOOKBEHIND))+1)
+	if keep > 0 && s.buf[keep] == '\n' && s.buf[keep-1] == '\r' && isCRLFValid(rptr) {
+		keep--
+	}
+	if keep > 0 {
+		n := copy(s.buf, s.buf[keep:])
+		s.buf = s.buf[:n]
+		s.base += int64(keep)
+	}
+
+	if len(s.buf) == cap(s.buf) {
+		if len(s.buf) >= s.maxSize {
+			s.err = bufio.ErrTooLong
+			return false
+		}
+		size := 2 * cap(s.buf)
+		if size < scannerReadSize {
+			size = scannerReadSize
+		}
+		if size > s.maxSize {
+			size = s.maxSize
+		}
+		buf := make([]byte, len(s.buf), size)
+		copy(buf, s.buf)
+		s.buf = buf
+	}
+
+	// Like bufio.Scanner, give up if the reader keeps returning
+	// no data and no error
+	for i := 0; i < 100; i++ {
+		n, err := s.reader.Read(s.buf[len(s.buf):cap(s.buf)])
+		s.buf = s.buf[:len(s.buf)+n]
+		if err == io.EOF {
+			s.eof = true
+			return true
+		}
+		if err != nil {
+			s.err = err
+			return false
+		}
+		if n > 0 {
+			return true
+		}
+	}
+	s.err = io.ErrNoProgress
+	return false
+}
+
+// Err returns the first error that was encountered by the Scanner,
+// other than io.EOF
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+// Index returns the offsets of the current match in the stream
+func (s *Scanner) Index() []int64 {
+	if len(s.index) == 0 {
+		return nil
+	}
+	return s.index[:2]
+}
+
+// SubmatchIndex returns the offsets in the stream of the current match
+// and its groups, in the same format as FindSubmatchIndex. Groups that
+// did not participate in the match are reported as -1. The returned
+// slice is overwritten by the next call to Scan.
+func (s *Scanner) SubmatchIndex() []int64 {
+	return s.index
+}
+
+// Bytes returns the text of the current match. The returned slice
+// refers to the Scanner's buffer, and may be overwritten by the next
+// call to Scan.
+func (s *Scanner) Bytes() []byte {
+	if len(s.index) == 0 {
+		return nil
+	}
+	return s.buf[s.index[0]-s.base : s.index[1]-s.base]
+}
+
+// Text returns the text of the current match as a string
+func (s *Scanner) Text() string {
+	return string(s.Bytes())
+}
+
+// Submatch returns the text of the current match and its groups, in
+// the same format as FindSubmatch. The returned slices refer to the
+// Scanner's buffer, and may be overwritten by the next call to Scan.
+func (s *Scanner) Submatch() [][]byte {
+	if len(s.index) == 0 {
+		return nil
+	}
+
+	ret := make([][]byte, len(s.index)/2)
+	for i := range ret {
+		if s.index[2*i] >= 0 {
+			ret[i] = s.buf[s.index[2*i]-s.base : s.index[2*i+1]-s.base]
+		}
+	}
+	return ret
+}
@@ -0,0 +1,265 @@
+package pcre2
+
+/*
+#define PCRE2_CODE_UNIT_WIDTH 8
+#include <pcre2.h>
+*/
+import "C"
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"unsafe"
+)
+
+// Serialized data starts with a header of our own, so that data from
+// an incompatible library can be reported before it is given to PCRE2:
+//
+//	magic          4 bytes, "GPC2"
+//	format         1 byte
+//	code unit      1 byte, the code unit width in bits
+//	version        1 byte length, followed by PCRE2's "major.minor"
+//	count          4 bytes, the number of patterns
+//
+// The header is followed by count entries, each holding the pattern
+// string (4 byte length and the bytes), the JIT modes (4 bytes), the
+// InvalidUTF8Mode (1 byte) and flags (1 byte, see serializeLongest and
+// serializePOSIX). They are followed by the length (8 bytes) and the
+// CRC-32 (4 bytes) of the output of pcre2_serialize_encode, which
+// makes up the rest of the data. pcre2_serialize_decode does no
+// checks of its own, so truncated or corrupted data must be rejected
+// before it gets there. Numbers are big endian.
+const (
+	serializeMagic         = "GPC2"
+	serializeFormat        = 3
+	serializeCodeUnitWidth = 8
+)
+
+// Flags of a serialized entry
+const (
+	serializeLongest = 1 << iota // Longest was called
+	serializePOSIX               // the Regexp was compiled by CompilePOSIX
+)
+
+// Error returns the string representation of the error.
+func (e ErrSerialize) Error() string {
+	return fmt.Sprintf("PCRE2 serialization failed (%d): %s", e.code, e.message)
+}
+
+// Error returns the string representation of the error.
+func (e ErrIncompatibleSerializedData) Error() string {
+	return fmt.Sprintf("serialized regexps were produced by PCRE2 %s with %d bit code units, but this is PCRE2 %s with %d bit code units",
+		e.version, e.codeUnitWidth, libraryVersion(), serializeCodeUnitWidth)
+}
+
+// libraryVersion returns the "major.minor" version of the PCRE2 library
+// that is in use. Serialized patterns can only be loaded by a library
+// of the same major and minor version.
+func libraryVersion() string {
+	n := C.pcre2_config(C.PCRE2_CONFIG_VERSION, nil)
+	if n <= 0 {
+		return ""
+	}
+	buf := make([]byte, int(n))
+	C.pcre2_config(C.PCRE2_CONFIG_VERSION, unsafe.Pointer(&buf[0]))
+
+	// The version looks like "10.42 2022-12-11"
+	version := string(buf[:bytes.IndexByte(buf, 0)])
+	if i := strings.IndexByte(version, ' '); i >= 0 {
+		version = version[:i]
+	}
+	return version
+}
+
+// SerializeRegexps saves the compiled form of the given Regexps, so
+// that they can be loaded by DeserializeRegexps without compiling the
+// patterns again. Along with the compiled code, the pattern strings,
+// the JIT modes, the InvalidUTF8Mode and whether leftmost-longest
+// matching is used are saved for each Regexp. Limits, callouts and JIT
+// stack sizes are not.
+//
+// The data can only be loaded by a program that uses a PCRE2 library
+// of the same major and minor version, on the same kind of machine.
+func SerializeRegexps(list []*Regexp) ([]byte, error) {
+	// Each Regexp is only acquired once, even if it appears more than
+	// once in the list, as nested acquires could deadlock with lock
+	codes := make([]*C.pcre2_code, len(list))
+	acquired := make(map[*Regexp]*C.pcre2_code, len(list))
+	defer func() {
+		for r := range acquired {
+			r.release()
+		}
+	}()
+
+	buf := []byte(serializeMagic)
+	buf = append(buf, serializeFormat, serializeCodeUnitWidth)
+	version := libraryVersion()
+	buf = append(buf, byte(len(version)))
+	buf = append(buf, version...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(list)))
+
+	for i, r := range list {
+		rptr, ok := acquired[r]
+		if !ok {
+			var err error
+			if rptr, err = r.acquire(); err != nil {
+				return nil, err
+			}
+			acquired[r] = rptr
+		}
+		codes[i] = rptr
+
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(r.pattern)))
+		buf = append(buf, r.pattern...)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(r.jit))
+		buf = append(buf, byte(r.invalidUTF8))
+
+		var flags byte
+		if r.longest {
+			flags |= serializeLongest
+		}
+		if r.posix {
+			flags |= serializePOSIX
+		}
+		buf = append(buf, flags)
+	}
+
+	if len(codes) == 0 {
+		return buf, nil
+	}
+
+	var serialized *C.uint8_t
+	var size C.PCRE2_SIZE
+	rc := C.pcre2_serialize_encode(
+		(**C.pcre2_code)(unsafe.Pointer(&codes[0])),
+		C.int32_t(len(codes)),
+		&serialized,
+		&size,
+		nil,
+	)
+	if rc < 0 {
+		return nil, ErrSerialize{code: int(rc), message: errorMessage(rc)}
+	}
+	defer C.pcre2_serialize_free(serialized)
+
+	encoded := unsafe.Slice((*byte)(unsafe.Pointer(serialized)), int(size))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(len(encoded)))
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(encoded))
+	return append(buf, encoded...), nil
+}
+
+// serializedEntry holds the per-Regexp state that is saved alongside
+// the compiled code
+type serializedEntry struct {
+	pattern     string
+	jit         JITOption
+	invalidUTF8 InvalidUTF8Mode
+	flags       byte
+}
+
+// DeserializeRegexps loads Regexps that were saved by SerializeRegexps.
+// The returned Regexps are independent of each other, and should be
+// released by calling Free as usual.
+//
+// If the data was produced by an incompatible PCRE2 library, the error
+// is an ErrIncompatibleSerializedData. Patterns that were JIT compiled
+// are JIT compiled again. If that fails, matching falls back to the
+// interpreter, just as when JIT fails on a freshly compiled pattern.
+func DeserializeRegexps(data []byte) ([]*Regexp, error) {
+	if len(data) < len(serializeMagic)+3 || string(data[:len(serializeMagic)]) != serializeMagic {
+		return nil, ErrInvalidSerializedData
+	}
+	data = data[len(serializeMagic):]
+	if data[0] != serializeFormat {
+		return nil, ErrInvalidSerializedData
+	}
+	width := int(data[1])
+	n := int(data[2])
+	data = data[3:]
+	if len(data) < n+4 {
+		return nil, ErrInvalidSerializedData
+	}
+	version := string(data[:n])
+	data = data[n:]
+	if width != serializeCodeUnitWidth || version != libraryVersion() {
+		return nil, ErrIncompatibleSerializedData{version: version, codeUnitWidth: width}
+	}
+
+	count := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	if uint64(count) > uint64(len(data)) {
+		// Each entry takes more than one byte
+		return nil, ErrInvalidSerializedData
+	}
+	entries := make([]serializedEntry, 0, int(count))
+	for i := 0; i < int(count); i++ {
+		if len(data) < 4 {
+			return nil, ErrInvalidSerializedData
+		}
+		n := binary.BigEndian.Uint32(data)
+		data = data[4:]
+		size := uint64(n) + 6
+		if uint64(len(data)) < size {
+			return nil, ErrInvalidSerializedData
+		}
+		e := serializedEntry{
+			pattern:     string(data[:n]),
+			jit:         JITOption(binary.BigEndian.Uint32(data[n:])),
+			invalidUTF8: InvalidUTF8Mode(data[n+4]),
+			flags:       data[n+5],
+		}
+		entries = append(entries, e)
+		data = data[size:]
+	}
+
+	if len(entries) == 0 {
+		if len(data) != 0 {
+			return nil, ErrInvalidSerializedData
+		}
+		return []*Regexp{}, nil
+	}
+
+	if len(data) < 12 {
+		return nil, ErrInvalidSerializedData
+	}
+	size := binary.BigEndian.Uint64(data)
+	sum := binary.BigEndian.Uint32(data[8:])
+	data = data[12:]
+	if size == 0 || uint64(len(data)) != size || crc32.ChecksumIEEE(data) != sum {
+		return nil, ErrInvalidSerializedData
+	}
+	if rc := C.pcre2_serialize_get_number_of_codes((*C.uint8_t)(unsafe.Pointer(&data[0]))); rc < 0 {
+		return nil, ErrSerialize{code: int(rc), message: errorMessage(rc)}
+	} else if int(rc) != len(entries) {
+		return nil, ErrInvalidSerializedData
+	}
+
+	codes := make([]*C.pcre2_code, len(entries))
+	rc := C.pcre2_serialize_decode(
+		(**C.pcre2_code)(unsafe.Pointer(&codes[0])),
+		C.int32_t(len(codes)),
+		(*C.uint8_t)(unsafe.Pointer(&data[0])),
+		nil,
+	)
+	if rc < 0 {
+		return nil, ErrSerialize{code: int(rc), message: errorMessage(rc)}
+	}
+
+	ret := make([]*Regexp, len(entries))
+	for i, e := range entries {
+		r := newRegexp(e.pattern, codes[i])
+		r.invalidUTF8 = e.invalidUTF8
+		r.posix = e.flags&serializePOSIX != 0
+		if e.flags&serializeLongest != 0 {
+			r.Longest()
+		}
+		if e.jit != 0 {
+			// JIT is an optimization only, so a failure is not fatal
+			_ = r.jitCompile(codes[i], e.jit)
+		}
+		ret[i] = r
+	}
+	return ret, nil
+}
@@ -0,0 +1,180 @@
+package pcre2
+
+/*
+#define PCRE2_CODE_UNIT_WIDTH 8
+#include <pcre2.h>
+
+#ifndef PCRE2_MATCH_INVALID_UTF
+#define PCRE2_MATCH_INVALID_UTF 0x04000000u
+#endif
+*/
+import "C"
+import (
+	"sort"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// Ways of handling subjects that are not valid UTF-8. See
+// Regexp.SetInvalidUTF8Mode
+const (
+	// InvalidUTF8Strict fails the match with a MatchError that
+	// matches ErrInvalidUTF8String. This is the default
+	InvalidUTF8Strict InvalidUTF8Mode = iota
+	// InvalidUTF8Replace treats each byte that is not part of a valid
+	// UTF-8 sequence as U+FFFD, so that it can be matched by . or
+	// \x{fffd}. Offsets are reported against the original subject.
+	InvalidUTF8Replace
+	// InvalidUTF8Skip uses PCRE2's PCRE2_MATCH_INVALID_UTF option, so
+	// that invalid bytes are never matched, and matches are only found
+	// within the valid parts of the subject. Requires PCRE2 10.34
+	InvalidUTF8Skip
+)
+
+// SetInvalidUTF8Mode sets how subjects that are not valid UTF-8 are
+// handled when matching against this Regexp. Switching to or from
+// InvalidUTF8Skip recompiles the pattern, and JIT compiles it again if
+// it was JIT compiled before. If that fails, matching falls back to the
+// interpreter, just as when JIT fails on a freshly compiled pattern.
+//
+// In InvalidUTF8Replace mode, Substitute copies U+FFFD into the result
+// in place of the invalid bytes, and offsets reported to callout
+// functions refer to the subject after the replacement.
+//
+// SetInvalidUTF8Mode waits for matches that are in progress to finish,
+// so it is best called before the Regexp is shared between goroutines.
+func (r *Regexp) SetInvalidUTF8Mode(mode InvalidUTF8Mode) error {
+	rptr, err := r.lock()
+	if err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	if (mode == InvalidUTF8Skip) != hasOption(rptr, C.PCRE2_MATCH_INVALID_UTF) {
+		var flags C.uint32_t
+		C.pcre2_pattern_info(rptr, C.PCRE2_INFO_ARGOPTIONS, unsafe.Pointer(&flags))
+		if mode == InvalidUTF8Skip {
+			flags |= C.PCRE2_MATCH_INVALID_UTF
+		} else {
+			flags &^= C.PCRE2_MATCH_INVALID_UTF
+		}
+
+		re, err := compile(r.pcre2Pattern(), Option(flags))
+		if err != nil {
+			return err
+		}
+		C.pcre2_code_free(rptr)
+		r.ptr = unsafe.Pointer(re)
+		r.invalidUTF8 = mode
+
+		if jit := r.jit; jit != 0 {
+			// JIT is an optimization only, so a failure is not fatal
+			r.jit = 0
+			_ = r.jitCompile(re, jit)
+		}
+
+		if r.longest {
+			if err := r.compileAutoCallout(re); err != nil {
+				return err
+			}
+		}
+	}
+
+	r.invalidUTF8 = mode
+	return nil
+}
+
+// InvalidUTF8Mode returns the mode set by SetInvalidUTF8Mode
+func (r *Regexp) InvalidUTF8Mode() InvalidUTF8Mode {
+	if _, err := r.acquire(); err != nil {
+		return InvalidUTF8Strict
+	}
+	defer r.release()
+
+	return r.invalidUTF8
+}
+
+// utf8Replacements holds the offsets in a subject at which U+FFFD was
+// inserted in place of an invalid byte
+type utf8Replacements []int
+
+// prepareSubject returns the subject that should be passed to PCRE2.
+// In InvalidUTF8Replace mode, invalid bytes are replaced by U+FFFD,
+// and the offsets of the replacements are returned, so that offsets
+// can be converted back with originalOffset.
+func (r *Regexp) prepareSubject(b []byte) ([]byte, utf8Replacements) {
+	if r.invalidUTF8 != InvalidUTF8Replace || utf8.Valid(b) {
+		return b, nil
+	}
+
+	out := make([]byte, 0, len(b)+8)
+	var repl utf8Replacements
+	for len(b) > 0 {
+		c, size := utf8.DecodeRune(b)
+		if c == utf8.RuneError && size == 1 {
+			repl = append(repl, len(out))
+		}
+		out = utf8.AppendRune(out, c)
+		b = b[size:]
+	}
+	return out, repl
+}
+
+// originalOffset converts a byte offset in the prepared subject to a
+// byte offset in the original subject. Negative offsets are returned
+// as is.
+func (repl utf8Replacements) originalOffset(i int) int {
+	if len(repl) == 0 || i < 0 {
+		return i
+	}
+
+	// Each U+FFFD that starts before i is 3 bytes long, in place of
+	// a single byte
+	n := sort.SearchInts(repl, i)
+	return i - n*(utf8.RuneLen(utf8.RuneError)-1)
+}
+
+// preparedOffset is the inverse of originalOffset: it converts a byte
+// offset in the original subject to a byte offset in the prepared one.
+func (repl utf8Replacements) preparedOffset(i int) int {
+	if len(repl) == 0 {
+		return i
+	}
+
+	// The replacement at repl[k] is at offset repl[k]-k*grow in the
+	// original subject
+	grow := utf8.RuneLen(utf8.RuneError) - 1
+	n := sort.Search(len(repl), func(k int) bool { return repl[k]-k*grow >= i })
+	return i + n*grow
+}
+
+// completeLen returns the length of b, minus any incomplete UTF-8
+// sequence at its end that may be completed by more input
+func completeLen(b []byte) int {
+	for i := 1; i < utf8.UTFMax && i <= len(b); i++ {
+		c := b[len(b)-i]
+		if c < utf8.RuneSelf {
+			break
+		}
+		if utf8.RuneStart(c) {
+			if !utf8.FullRune(b[len(b)-i:]) {
+				return len(b) - i
+			}
+			break
+		}
+	}
+	return len(b)
+}
+
+// invalidIndex returns the offset of the first byte in b that is not
+// part of a valid UTF-8 sequence, or -1 if b is valid UTF-8
+func invalidIndex(b []byte) int {
+	for i := 0; i < len(b); {
+		c, size := utf8.DecodeRune(b[i:])
+		if c == utf8.RuneError && size == 1 {
+			return i
+		}
+		i += size
+	}
+	return -1
+}